@@ -1,21 +1,38 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
+	"defaultallowpe/pkg/certificate"
 	"defaultallowpe/pkg/config"
+	"defaultallowpe/pkg/health"
+	"defaultallowpe/pkg/kube"
+	"defaultallowpe/pkg/metrics"
+	"defaultallowpe/pkg/mutate"
+	"defaultallowpe/pkg/policy"
+	"defaultallowpe/pkg/psp"
 	"defaultallowpe/pkg/webhook"
+	"fmt"
 	stdlog "log"
 	"net"
-	"path/filepath"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
-	"github.com/fsnotify/fsnotify"
 	"go.uber.org/zap"
 
 	"github.com/cloudflare/certinel"
 	"github.com/cloudflare/certinel/fswatcher"
+	"k8s.io/client-go/informers"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == psp.Name {
+		os.Exit(psp.Command(os.Args[2:], os.Stdin, os.Stdout, os.Stderr))
+	}
+
 	logConfig := zap.NewProductionConfig()
 	logConfig.Sampling = nil
 	logger, err := logConfig.Build()
@@ -36,50 +53,220 @@ func main() {
 
 	config, err := config.New()
 	if err != nil {
-		log.Fatalw("config file found but another error was produced",
+		log.Fatalw("unable to load config",
 			"err", err,
 		)
 	}
-	level := zap.NewAtomicLevel()
-	err = level.UnmarshalText(([]byte(config.GetString("logging.level"))))
-	if err != nil {
+	cfg := config.Load()
+	if err := logConfig.Level.UnmarshalText([]byte(cfg.Logging.Level)); err != nil {
 		log.Fatalw("invalid log level",
 			"err", err,
 		)
 	}
-	logConfig.Level.SetLevel(level.Level())
 
-	config.OnConfigChange(func(e fsnotify.Event) {
-		log.Info("config file changed")
+	err = config.OnReload(func(changed []string, restart []string) {
+		// validated on reload, zap.AtomicLevel swaps the level for every logger
+		_ = logConfig.Level.UnmarshalText([]byte(config.Load().Logging.Level))
+		metrics.ConfigReloads.WithLabelValues(metrics.ReloadApplied).Inc()
+		log.Infow("config file reloaded",
+			"changed", changed,
+			"level", logConfig.Level.String(),
+		)
+		if len(restart) > 0 {
+			log.Warnw("config keys only read on startup changed, restart to apply",
+				"keys", restart,
+			)
+		}
+	}, func(err error) {
+		metrics.ConfigReloads.WithLabelValues(metrics.ReloadRejected).Inc()
+		log.Errorw("config file rejected, keeping last good config",
+			"err", err,
+		)
 	})
+	if err != nil {
+		log.Fatalw("unable to watch config file",
+			"err", err,
+		)
+	}
+
+	state := &health.State{}
+	// liveness is intentionally process-only: every check depends on the API server, the certificate or the config,
+	// which a restart cannot fix, so failing them only takes the pod out of the Service
+	checks := health.Checks{
+		Readiness: []health.Checker{state, health.Config(config)},
+	}
+	listers := mutate.Listers{}
+	stopCh := make(chan struct{})
+	lookupNamespaces := cfg.Namespaces.Lookup
+	policiesEnabled := cfg.Policies.Enabled
+	if lookupNamespaces || policiesEnabled {
+		client, err := kube.NewClientset()
+		if err != nil {
+			log.Fatalw("unable to create kubernetes client",
+				"err", err,
+			)
+		}
+		factory := informers.NewSharedInformerFactory(client, 0)
+		namespaces := factory.Core().V1().Namespaces().Lister()
+		checks.Readiness = append(checks.Readiness, health.Synced("namespaces", factory.Core().V1().Namespaces().Informer().HasSynced))
+		if lookupNamespaces {
+			listers.Namespaces = namespaces
+		}
+		factory.Start(stopCh)
+		for informer, synced := range factory.WaitForCacheSync(stopCh) {
+			if !synced {
+				log.Fatalw("informer cache failed to sync",
+					"informer", informer.String(),
+				)
+			}
+		}
+
+		if policiesEnabled {
+			dynamicClient, err := kube.NewDynamicClient()
+			if err != nil {
+				log.Fatalw("unable to create kubernetes dynamic client",
+					"err", err,
+				)
+			}
+			listers.Policies = policy.NewStore(namespaces)
+			controller := policy.NewController(dynamicClient, listers.Policies, log, cfg.Policies.StatusInterval)
+			if err := controller.Start(stopCh); err != nil {
+				log.Fatalw("policy controller failed to start",
+					"err", err,
+				)
+			}
+			checks.Readiness = append(checks.Readiness, health.Synced("policies", controller.HasSynced))
+		}
+	}
+
+	var metricsServer *http.Server
+	if cfg.Metrics.Enabled {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+		metricsServer = &http.Server{
+			Addr:              fmt.Sprintf(":%d", cfg.Metrics.Port),
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalw("metrics server failed",
+					"err", err,
+				)
+			}
+		}()
+	}
 
-	app := webhook.New(config)
-	ln, err := net.Listen("tcp", ":"+config.GetString("server.port"))
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.Server.Port))
 	if err != nil {
 		log.Fatalw("tcp listener failed",
 			"err", err,
 		)
 	}
-	if config.GetBool("server.tls.enabled") {
-		watcher, err := fswatcher.New(
-			filepath.Join(config.GetString("server.tls.dir"), config.GetString("server.tls.certFile")),
-			filepath.Join(config.GetString("server.tls.dir"), config.GetString("server.tls.keyFile")),
+	var sentinel *certinel.Certinel
+	var monitor *certificate.Monitor
+	if cfg.Server.TLS.Enabled {
+		var getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+		if cfg.Server.TLS.SelfManaged.Enabled {
+			client, err := kube.NewClientset()
+			if err != nil {
+				log.Fatalw("unable to create kubernetes client",
+					"err", err,
+				)
+			}
+			manager := certificate.NewManager(client, cfg.Server.TLS.SelfManaged, log)
+			if err := manager.Reconcile(context.Background()); err != nil {
+				log.Fatalw("unable to issue self-managed certificate",
+					"err", err,
+				)
+			}
+			manager.Start(stopCh)
+			getCertificate = manager.GetCertificate
+		} else {
+			watcher, err := fswatcher.New(
+				cfg.Server.TLS.Path(cfg.Server.TLS.CertFile),
+				cfg.Server.TLS.Path(cfg.Server.TLS.KeyFile),
+			)
+			if err != nil {
+				log.Fatalw("unable to read server certificate",
+					"err", err,
+				)
+			}
+			sentinel = certinel.New(watcher, func(err error) {
+				log.Warnw("certinel was unable to reload the certificate",
+					"err", err,
+				)
+			})
+			sentinel.Watch()
+			getCertificate = sentinel.GetCertificate
+		}
+		// validated with the rest of the config
+		tlsConfig, _ := cfg.Server.TLS.TLSConfig()
+		tlsConfig.GetCertificate = getCertificate
+		if cfg.Server.TLS.ClientCA != "" {
+			clientAuth, err := certificate.NewClientAuth(cfg.Server.TLS.Path(cfg.Server.TLS.ClientCA), cfg.Server.TLS.ClientCommonNames, cfg.Server.TLS.ClientSANs, log)
+			if err != nil {
+				log.Fatalw("unable to read client CA bundle",
+					"err", err,
+				)
+			}
+			if err := clientAuth.Watch(stopCh); err != nil {
+				log.Fatalw("unable to watch client CA bundle",
+					"err", err,
+				)
+			}
+			tlsConfig = clientAuth.Configure(tlsConfig)
+		}
+		ln = tls.NewListener(ln, tlsConfig)
+		log.Infow("TLS configured", cfg.Server.TLS.LogFields()...)
+		served := func() (*tls.Certificate, error) {
+			return getCertificate(nil)
+		}
+		checks.Readiness = append(checks.Readiness, health.Certificate(served))
+		monitor = certificate.NewMonitor(served, log, cfg.Server.TLS.ExpiryWarning)
+		monitor.Start(stopCh)
+	}
+
+	app := webhook.New(config, log, listers, checks, monitor)
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- app.Listener(ln)
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	select {
+	case err := <-serverErr:
+		log.Fatalw("webhook server failed",
+			"err", err,
+		)
+	case sig := <-signals:
+		log.Infow("shutting down",
+			"signal", sig.String(),
+			"drain", cfg.Server.Shutdown.Drain,
+			"timeout", cfg.Server.Shutdown.Timeout,
+		)
+	}
+
+	if err := webhook.Shutdown(app, state, cfg.Server.Shutdown.Drain, cfg.Server.Shutdown.Timeout); err != nil {
+		log.Errorw("webhook server did not shut down cleanly",
+			"err", err,
 		)
-		if err != nil {
-			log.Fatalw("unable to read server certificate",
+	}
+	if sentinel != nil {
+		if err := sentinel.Close(); err != nil {
+			log.Warnw("unable to stop certificate watcher",
 				"err", err,
 			)
 		}
-		sentinel := certinel.New(watcher, func(err error) {
-			log.Warnw("certinel was unable to reload the certificate",
+	}
+	close(stopCh)
+	if metricsServer != nil {
+		if err := metricsServer.Close(); err != nil {
+			log.Warnw("unable to stop metrics server",
 				"err", err,
 			)
-		})
-		sentinel.Watch()
-		ln = tls.NewListener(ln, &tls.Config{GetCertificate: sentinel.GetCertificate, MinVersion: tls.VersionTLS12})
+		}
 	}
-	err = app.Listener(ln)
-	log.Fatalw("webhook server failed",
-		"err", err,
-	)
+	log.Info("shutdown complete")
 }
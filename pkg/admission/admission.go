@@ -0,0 +1,223 @@
+package admission
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+
+	"github.com/gofiber/fiber/v2"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+)
+
+var (
+	scheme       = runtime.NewScheme()
+	codecs       = serializer.NewCodecFactory(scheme)
+	deserializer = codecs.UniversalDeserializer()
+)
+
+// EphemeralContainersSubResource is the pod subresource used to add ephemeral containers
+const EphemeralContainersSubResource = "ephemeralcontainers"
+
+// Error is an error response body
+type Error struct {
+	Status  int    `json:"-"`
+	Message string `json:"error"`
+}
+
+// PodTemplate locates the pod metadata and spec within an admitted object
+type PodTemplate struct {
+	// Meta is the metadata of the admitted object
+	Meta *metav1.ObjectMeta
+	// Template is the metadata of the pod or pod template
+	Template *metav1.ObjectMeta
+	Spec     *corev1.PodSpec
+	// Path is the JSON pointer of the pod spec
+	Path string
+	// Immutable is set when the containers of the pod template cannot change once the object exists, apart from
+	// ephemeral containers added through the subresource
+	Immutable bool
+	// Managed is set when the pod template is copied from the controller owning the object, which compares them
+	Managed bool
+}
+
+// Container references a container of a pod spec by its spec field and index
+type Container struct {
+	Kind            string
+	Field           string
+	Index           int
+	Name            string
+	Image           string
+	SecurityContext *corev1.SecurityContext
+}
+
+func init() {
+	utilruntime.Must(corev1.AddToScheme(scheme))
+	utilruntime.Must(appsv1.AddToScheme(scheme))
+	utilruntime.Must(batchv1.AddToScheme(scheme))
+	utilruntime.Must(admissionv1.AddToScheme(scheme))
+	utilruntime.Must(admissionv1beta1.AddToScheme(scheme))
+}
+
+// convert copies between the v1 and v1beta1 AdmissionReview types, which share the same serialization
+func convert(in interface{}, out interface{}) error {
+	b, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, out)
+}
+
+// ReadReview decodes the AdmissionReview sent in the request body, v1beta1 reviews are converted to v1 and keep their
+// apiVersion so they are answered in the same version
+func ReadReview(c *fiber.Ctx) (*admissionv1.AdmissionReview, *Error) {
+	// validate Content-Type
+	if !c.Is("json") {
+		return nil, &Error{
+			Status:  fiber.StatusUnsupportedMediaType,
+			Message: "invalid content-type, expected application/json",
+		}
+	}
+
+	// get AdmissionReview
+	reviewGVK := admissionv1.SchemeGroupVersion.WithKind("AdmissionReview")
+	obj, gvk, err := deserializer.Decode(c.Body(), &reviewGVK, nil)
+	if err != nil {
+		return nil, &Error{
+			Status:  fiber.StatusBadRequest,
+			Message: "could not decode AdmissionReview",
+		}
+	}
+
+	var review *admissionv1.AdmissionReview
+	switch r := obj.(type) {
+	case *admissionv1.AdmissionReview:
+		review = r
+	case *admissionv1beta1.AdmissionReview:
+		review = &admissionv1.AdmissionReview{}
+		if err := convert(r, review); err != nil {
+			return nil, &Error{
+				Status:  fiber.StatusBadRequest,
+				Message: "could not convert AdmissionReview",
+			}
+		}
+	default:
+		return nil, &Error{
+			Status:  fiber.StatusBadRequest,
+			Message: fmt.Sprintf("unexpected GroupVersionKind: %s", gvk),
+		}
+	}
+	review.SetGroupVersionKind(*gvk)
+
+	// check if request is empty
+	if review.Request == nil {
+		return nil, &Error{
+			Status:  fiber.StatusBadRequest,
+			Message: "unexpected nil AdmissionRequest",
+		}
+	}
+	return review, nil
+}
+
+// WriteReview responds with the AdmissionReview carrying the AdmissionResponse in the version it was sent as
+func WriteReview(c *fiber.Ctx, review *admissionv1.AdmissionReview, response *admissionv1.AdmissionResponse) error {
+	review.Response = response
+	review.Response.UID = review.Request.UID
+	if review.GroupVersionKind().GroupVersion() == admissionv1beta1.SchemeGroupVersion {
+		var out admissionv1beta1.AdmissionReview
+		if err := convert(review, &out); err != nil {
+			return err
+		}
+		return c.Status(fiber.StatusOK).JSON(&out)
+	}
+	return c.Status(fiber.StatusOK).JSON(review)
+}
+
+// DecodeObject decodes the object of an AdmissionRequest
+func DecodeObject(raw []byte) (runtime.Object, error) {
+	obj, _, err := deserializer.Decode(raw, nil, nil)
+	return obj, err
+}
+
+// MatchGlob returns the first pattern matching the value, patterns are exact values or globs
+func MatchGlob(patterns []string, value string) (string, bool) {
+	for _, pattern := range patterns {
+		if matched, err := path.Match(pattern, value); err == nil && matched {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+// ownedByDeployment checks if the controller of an object is a Deployment
+func ownedByDeployment(meta *metav1.ObjectMeta) bool {
+	owner := metav1.GetControllerOfNoCopy(meta)
+	return owner != nil && owner.Kind == "Deployment" && owner.APIVersion == appsv1.SchemeGroupVersion.String()
+}
+
+// PodTemplateFor locates the pod template of supported objects
+func PodTemplateFor(obj runtime.Object) (*PodTemplate, bool) {
+	switch o := obj.(type) {
+	case *corev1.Pod:
+		return &PodTemplate{Meta: &o.ObjectMeta, Template: &o.ObjectMeta, Spec: &o.Spec, Path: "/spec", Immutable: true}, true
+	case *appsv1.Deployment:
+		return &PodTemplate{Meta: &o.ObjectMeta, Template: &o.Spec.Template.ObjectMeta, Spec: &o.Spec.Template.Spec, Path: "/spec/template/spec"}, true
+	case *appsv1.StatefulSet:
+		return &PodTemplate{Meta: &o.ObjectMeta, Template: &o.Spec.Template.ObjectMeta, Spec: &o.Spec.Template.Spec, Path: "/spec/template/spec"}, true
+	case *appsv1.DaemonSet:
+		return &PodTemplate{Meta: &o.ObjectMeta, Template: &o.Spec.Template.ObjectMeta, Spec: &o.Spec.Template.Spec, Path: "/spec/template/spec"}, true
+	case *appsv1.ReplicaSet:
+		return &PodTemplate{Meta: &o.ObjectMeta, Template: &o.Spec.Template.ObjectMeta, Spec: &o.Spec.Template.Spec, Path: "/spec/template/spec", Managed: ownedByDeployment(&o.ObjectMeta)}, true
+	case *batchv1.Job:
+		return &PodTemplate{Meta: &o.ObjectMeta, Template: &o.Spec.Template.ObjectMeta, Spec: &o.Spec.Template.Spec, Path: "/spec/template/spec", Immutable: true}, true
+	case *batchv1.CronJob:
+		return &PodTemplate{Meta: &o.ObjectMeta, Template: &o.Spec.JobTemplate.Spec.Template.ObjectMeta, Spec: &o.Spec.JobTemplate.Spec.Template.Spec, Path: "/spec/jobTemplate/spec/template/spec"}, true
+	case *corev1.EphemeralContainers:
+		// sent for the pods/ephemeralcontainers subresource by API servers prior to v1.22
+		return &PodTemplate{Meta: &o.ObjectMeta, Template: &o.ObjectMeta, Spec: &corev1.PodSpec{EphemeralContainers: o.EphemeralContainers}, Path: ""}, true
+	}
+	return nil, false
+}
+
+// ExistingEphemeralContainers lists the ephemeral containers of the old object, which can no longer be changed
+func ExistingEphemeralContainers(raw []byte) (map[string]bool, error) {
+	existing := map[string]bool{}
+	if len(raw) == 0 {
+		return existing, nil
+	}
+	obj, err := DecodeObject(raw)
+	if err != nil {
+		return nil, err
+	}
+	if pt, ok := PodTemplateFor(obj); ok {
+		for _, c := range pt.Spec.EphemeralContainers {
+			existing[c.Name] = true
+		}
+	}
+	return existing, nil
+}
+
+// Containers lists the containers of the pod spec, limited to ephemeral containers when requested
+func (pt *PodTemplate) Containers(ephemeralOnly bool) []Container {
+	var containers []Container
+	if !ephemeralOnly {
+		for i, c := range pt.Spec.InitContainers {
+			containers = append(containers, Container{Kind: "init container", Field: "initContainers", Index: i, Name: c.Name, Image: c.Image, SecurityContext: c.SecurityContext})
+		}
+		for i, c := range pt.Spec.Containers {
+			containers = append(containers, Container{Kind: "container", Field: "containers", Index: i, Name: c.Name, Image: c.Image, SecurityContext: c.SecurityContext})
+		}
+	}
+	for i, c := range pt.Spec.EphemeralContainers {
+		containers = append(containers, Container{Kind: "ephemeral container", Field: "ephemeralContainers", Index: i, Name: c.Name, Image: c.Image, SecurityContext: c.SecurityContext})
+	}
+	return containers
+}
@@ -0,0 +1,82 @@
+package admission
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tt := []struct {
+		name     string
+		patterns []string
+		value    string
+		expected string
+		matched  bool
+	}{
+		{
+			name:     "exact",
+			patterns: []string{"kube-system"},
+			value:    "kube-system",
+			expected: "kube-system",
+			matched:  true,
+		},
+		{
+			name:     "glob",
+			patterns: []string{"foo", "kube-*"},
+			value:    "kube-public",
+			expected: "kube-*",
+			matched:  true,
+		},
+		{
+			name:     "invalid pattern",
+			patterns: []string{"["},
+			value:    "[",
+		},
+		{
+			name:  "no patterns",
+			value: "default",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			pattern, matched := MatchGlob(tc.patterns, tc.value)
+			if matched != tc.matched {
+				t.Errorf("expected matched %t, got %t", tc.matched, matched)
+			}
+			if pattern != tc.expected {
+				t.Errorf("expected pattern %s, got %s", tc.expected, pattern)
+			}
+		})
+	}
+}
+
+func TestContainers(t *testing.T) {
+	pt, ok := PodTemplateFor(&corev1.Pod{
+		Spec: corev1.PodSpec{
+			InitContainers:      []corev1.Container{{Name: "init"}},
+			Containers:          []corev1.Container{{Name: "app"}},
+			EphemeralContainers: []corev1.EphemeralContainer{{EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "debug"}}},
+		},
+	})
+	if !ok {
+		t.Fatal("expected pod template for Pod")
+	}
+
+	names := func(containers []Container) []string {
+		var names []string
+		for _, c := range containers {
+			names = append(names, c.Field+"/"+c.Name)
+		}
+		return names
+	}
+	all := names(pt.Containers(false))
+	if len(all) != 3 || all[0] != "initContainers/init" || all[1] != "containers/app" || all[2] != "ephemeralContainers/debug" {
+		t.Errorf("unexpected containers %v", all)
+	}
+	ephemeral := names(pt.Containers(true))
+	if len(ephemeral) != 1 || ephemeral[0] != "ephemeralContainers/debug" {
+		t.Errorf("unexpected ephemeral containers %v", ephemeral)
+	}
+}
@@ -0,0 +1,177 @@
+package certificate
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"defaultallowpe/pkg/metrics"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// checkInterval is how often the served certificate is inspected
+const checkInterval = time.Minute
+
+// Info model describing the served certificate
+type Info struct {
+	Subject     string    `json:"subject"`
+	DNSNames    []string  `json:"dnsNames"`
+	IPAddresses []string  `json:"ipAddresses"`
+	NotBefore   time.Time `json:"notBefore"`
+	NotAfter    time.Time `json:"notAfter"`
+	ExpiresIn   string    `json:"expiresIn"`
+	Expired     bool      `json:"expired"`
+}
+
+// Leaf returns the parsed leaf of a certificate chain
+func Leaf(cert *tls.Certificate) (*x509.Certificate, error) {
+	if cert == nil || len(cert.Certificate) == 0 {
+		return nil, errors.New("no certificate loaded")
+	}
+	if cert.Leaf != nil {
+		return cert.Leaf, nil
+	}
+	return x509.ParseCertificate(cert.Certificate[0])
+}
+
+// Describe summarizes a certificate as of now
+func Describe(cert *tls.Certificate, now time.Time) (*Info, error) {
+	leaf, err := Leaf(cert)
+	if err != nil {
+		return nil, err
+	}
+	info := &Info{
+		Subject:     leaf.Subject.String(),
+		DNSNames:    leaf.DNSNames,
+		IPAddresses: []string{},
+		NotBefore:   leaf.NotBefore,
+		NotAfter:    leaf.NotAfter,
+		ExpiresIn:   leaf.NotAfter.Sub(now).Truncate(time.Second).String(),
+		Expired:     now.After(leaf.NotAfter),
+	}
+	if info.DNSNames == nil {
+		info.DNSNames = []string{}
+	}
+	for _, ip := range leaf.IPAddresses {
+		info.IPAddresses = append(info.IPAddresses, ip.String())
+	}
+	return info, nil
+}
+
+// Monitor periodically inspects the served certificate, reporting it through metrics and warning before it expires
+type Monitor struct {
+	get     func() (*tls.Certificate, error)
+	log     *zap.SugaredLogger
+	warning time.Duration
+	now     func() time.Time
+
+	mu      sync.RWMutex
+	info    *Info
+	err     error
+	alerted alert
+}
+
+// alert identifies the last expiry warning logged
+type alert struct {
+	notAfter time.Time
+	expired  bool
+}
+
+// NewMonitor creates a monitor warning when the certificate expires within the warning window
+func NewMonitor(get func() (*tls.Certificate, error), log *zap.SugaredLogger, warning time.Duration) *Monitor {
+	return &Monitor{
+		get:     get,
+		log:     log,
+		warning: warning,
+		now:     time.Now,
+	}
+}
+
+// Start inspects the certificate until stopped
+func (m *Monitor) Start(stopCh <-chan struct{}) {
+	go wait.Until(m.check, checkInterval, stopCh)
+}
+
+// Info returns the last inspection of the certificate
+func (m *Monitor) Info() (*Info, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.info == nil && m.err == nil {
+		return nil, errors.New("certificate not inspected yet")
+	}
+	return m.info, m.err
+}
+
+func (m *Monitor) check() {
+	cert, err := m.get()
+	var info *Info
+	if err == nil {
+		info, err = Describe(cert, m.now())
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	previous := m.info
+	m.info, m.err = info, err
+	metrics.CertificateNotAfter.Reset()
+	if err != nil {
+		m.log.Warnw("unable to inspect serving certificate",
+			"err", err,
+		)
+		return
+	}
+
+	metrics.CertificateNotAfter.WithLabelValues(info.Subject, strings.Join(info.DNSNames, ",")).Set(float64(info.NotAfter.Unix()))
+	if previous == nil || !previous.NotAfter.Equal(info.NotAfter) || previous.Subject != info.Subject {
+		m.log.Infow("serving certificate loaded",
+			"subject", info.Subject,
+			"dnsNames", info.DNSNames,
+			"ipAddresses", info.IPAddresses,
+			"notAfter", info.NotAfter,
+		)
+	}
+
+	// warn once per certificate when it enters the warning window and again once it has expired
+	if !info.Expired && info.NotAfter.Sub(m.now()) >= m.warning {
+		return
+	}
+	if m.alerted.notAfter.Equal(info.NotAfter) && m.alerted.expired == info.Expired {
+		return
+	}
+	m.alerted = alert{notAfter: info.NotAfter, expired: info.Expired}
+	if info.Expired {
+		m.log.Errorw("serving certificate expired",
+			"subject", info.Subject,
+			"notAfter", info.NotAfter,
+		)
+		return
+	}
+	m.log.Warnw("serving certificate expires soon",
+		"subject", info.Subject,
+		"notAfter", info.NotAfter,
+		"expiresIn", info.ExpiresIn,
+	)
+}
+
+// Routes manages Fiber routes for certificate pkg
+func Routes(r fiber.Router, monitor *Monitor) {
+	r.Get("/status/certificate", HandlerFunc(monitor))
+}
+
+// HandlerFunc returns a func that is a HTTP handler for certificate status requests
+func HandlerFunc(monitor *Monitor) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		info, err := monitor.Info()
+		if err != nil {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+		return c.JSON(info)
+	}
+}
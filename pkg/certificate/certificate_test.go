@@ -0,0 +1,160 @@
+package certificate
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"defaultallowpe/pkg/metrics"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func selfSigned(t *testing.T, notAfter time.Time) *tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "webhook"},
+		DNSNames:     []string{"webhook.default-allow-privilege-escalation.svc"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    notAfter.Add(-90 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestDescribe(t *testing.T) {
+	// certificates have second precision
+	now := time.Now().Truncate(time.Second)
+	info, err := Describe(selfSigned(t, now.Add(time.Hour)), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Subject != "CN=webhook" {
+		t.Errorf("expected subject %s, got %s", "CN=webhook", info.Subject)
+	}
+	if len(info.DNSNames) != 1 || info.DNSNames[0] != "webhook.default-allow-privilege-escalation.svc" {
+		t.Errorf("expected dnsNames [webhook.default-allow-privilege-escalation.svc], got %v", info.DNSNames)
+	}
+	if len(info.IPAddresses) != 1 || info.IPAddresses[0] != "127.0.0.1" {
+		t.Errorf("expected ipAddresses [127.0.0.1], got %v", info.IPAddresses)
+	}
+	if info.Expired {
+		t.Error("expected expired false, got expired true")
+	}
+	if info.ExpiresIn != "1h0m0s" {
+		t.Errorf("expected expiresIn %s, got %s", "1h0m0s", info.ExpiresIn)
+	}
+
+	if _, err := Describe(nil, now); err == nil {
+		t.Error("expected error, got none")
+	}
+}
+
+func TestMonitor(t *testing.T) {
+	now := time.Now()
+	tt := []struct {
+		name     string
+		notAfter time.Time
+		messages []string
+	}{
+		{
+			name:     "valid",
+			notAfter: now.Add(30 * 24 * time.Hour),
+			messages: []string{"serving certificate loaded"},
+		},
+		{
+			name:     "expires soon",
+			notAfter: now.Add(24 * time.Hour),
+			messages: []string{"serving certificate loaded", "serving certificate expires soon"},
+		},
+		{
+			name:     "expired",
+			notAfter: now.Add(-time.Hour),
+			messages: []string{"serving certificate loaded", "serving certificate expired"},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			core, logs := observer.New(zapcore.InfoLevel)
+			cert := selfSigned(t, tc.notAfter)
+			monitor := NewMonitor(func() (*tls.Certificate, error) {
+				return cert, nil
+			}, zap.New(core).Sugar(), 7*24*time.Hour)
+			monitor.now = func() time.Time { return now }
+
+			// repeated checks of the same certificate only log once
+			monitor.check()
+			monitor.check()
+
+			entries := logs.AllUntimed()
+			if len(entries) != len(tc.messages) {
+				t.Fatalf("expected %d log entries, got %d", len(tc.messages), len(entries))
+			}
+			for i, entry := range entries {
+				if entry.Message != tc.messages[i] {
+					t.Errorf("expected log entry %q, got %q", tc.messages[i], entry.Message)
+				}
+			}
+			notAfter := testutil.ToFloat64(metrics.CertificateNotAfter.WithLabelValues("CN=webhook", "webhook.default-allow-privilege-escalation.svc"))
+			if notAfter != float64(tc.notAfter.Unix()) {
+				t.Errorf("expected not after %d, got %v", tc.notAfter.Unix(), notAfter)
+			}
+		})
+	}
+}
+
+func TestCertificateApi(t *testing.T) {
+	cert := selfSigned(t, time.Now().Add(time.Hour))
+	monitor := NewMonitor(func() (*tls.Certificate, error) {
+		return cert, nil
+	}, zap.NewNop().Sugar(), time.Hour)
+
+	app := fiber.New()
+	Routes(app.Group(""), monitor)
+
+	res, _ := app.Test(httptest.NewRequest("GET", "/status/certificate", nil))
+	if res.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected status code %d, got %d", http.StatusServiceUnavailable, res.StatusCode)
+	}
+
+	monitor.check()
+	res, _ = app.Test(httptest.NewRequest("GET", "/status/certificate", nil))
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("expected status code %d, got %d", http.StatusOK, res.StatusCode)
+	}
+	bodyBytes, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	var info Info
+	err = json.Unmarshal(bodyBytes, &info)
+	if err != nil {
+		t.Fatal("failed to json decode res body")
+	}
+	if info.Subject != "CN=webhook" {
+		t.Errorf("expected subject %s, got %s", "CN=webhook", info.Subject)
+	}
+}
@@ -0,0 +1,155 @@
+package certificate
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"defaultallowpe/pkg/admission"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+// ClientAuth verifies client certificates against a CA bundle that is reloaded on change, optionally restricting
+// the permitted common names and SANs
+type ClientAuth struct {
+	file        string
+	commonNames []string
+	sans        []string
+	log         *zap.SugaredLogger
+
+	pool atomic.Value
+	last []byte
+}
+
+// NewClientAuth creates a client authenticator from a PEM encoded CA bundle
+func NewClientAuth(file string, commonNames, sans []string, log *zap.SugaredLogger) (*ClientAuth, error) {
+	a := &ClientAuth{
+		file:        file,
+		commonNames: commonNames,
+		sans:        sans,
+		log:         log,
+	}
+	if _, err := a.load(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// load reads the CA bundle, reporting whether it changed
+func (a *ClientAuth) load() (bool, error) {
+	contents, err := ioutil.ReadFile(a.file)
+	if err != nil {
+		return false, err
+	}
+	if a.last != nil && bytes.Equal(contents, a.last) {
+		return false, nil
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(contents) {
+		return false, fmt.Errorf("no certificates found in client CA bundle %s", a.file)
+	}
+	a.pool.Store(pool)
+	a.last = contents
+	return true, nil
+}
+
+// Watch reloads the CA bundle whenever its directory changes, which includes Secret and ConfigMap updates
+func (a *ClientAuth) Watch(stopCh <-chan struct{}) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(filepath.Dir(a.file)); err != nil {
+		watcher.Close()
+		return err
+	}
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case <-stopCh:
+				return
+			case _, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				changed, err := a.load()
+				if err != nil {
+					a.log.Warnw("unable to reload client CA bundle, keeping last good bundle",
+						"file", a.file,
+						"err", err,
+					)
+					continue
+				}
+				if changed {
+					a.log.Infow("client CA bundle reloaded",
+						"file", a.file,
+					)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				a.log.Warnw("client CA bundle watcher failed",
+					"err", err,
+				)
+			}
+		}
+	}()
+	return nil
+}
+
+// Configure verifies client certificates given during the handshake, RequireClient rejects requests without one
+func (a *ClientAuth) Configure(c *tls.Config) *tls.Config {
+	c.ClientAuth = tls.VerifyClientCertIfGiven
+	c.VerifyPeerCertificate = a.verify
+	c.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		config := c.Clone()
+		config.GetConfigForClient = nil
+		config.ClientCAs = a.pool.Load().(*x509.CertPool)
+		return config, nil
+	}
+	return c
+}
+
+// verify checks the verified client certificate against the permitted common names and SANs
+func (a *ClientAuth) verify(_ [][]byte, chains [][]*x509.Certificate) error {
+	if len(chains) == 0 || len(chains[0]) == 0 || (len(a.commonNames) == 0 && len(a.sans) == 0) {
+		return nil
+	}
+	leaf := chains[0][0]
+	if _, ok := admission.MatchGlob(a.commonNames, leaf.Subject.CommonName); ok {
+		return nil
+	}
+	sans := append([]string{}, leaf.DNSNames...)
+	sans = append(sans, leaf.EmailAddresses...)
+	for _, ip := range leaf.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, uri := range leaf.URIs {
+		sans = append(sans, uri.String())
+	}
+	for _, san := range sans {
+		if _, ok := admission.MatchGlob(a.sans, san); ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("client certificate %q not permitted", leaf.Subject.CommonName)
+}
+
+// RequireClient rejects requests that did not present a verified client certificate
+func RequireClient(c *fiber.Ctx) error {
+	state := c.Context().TLSConnectionState()
+	if state == nil || len(state.VerifiedChains) == 0 {
+		return c.Status(fiber.StatusUnauthorized).JSON(map[string]interface{}{
+			"error": "client certificate required",
+		})
+	}
+	return c.Next()
+}
@@ -0,0 +1,223 @@
+package certificate
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+type authority struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newAuthority(t *testing.T, name string) authority {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return authority{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+func (a authority) issue(t *testing.T, commonName string, dnsNames ...string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     dnsNames,
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, a.cert, &key.PublicKey, a.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// writeBundle replaces the CA bundle in a single step, like a Secret update
+func writeBundle(t *testing.T, file string, contents []byte) {
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, contents, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certificate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	clientCA := newAuthority(t, "client-ca")
+	otherCA := newAuthority(t, "other-ca")
+	bundle := filepath.Join(dir, "client-ca.crt")
+	writeBundle(t, bundle, clientCA.pem)
+
+	auth, err := NewClientAuth(bundle, []string{"kube-apiserver"}, []string{"*.example.com"}, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	if err := auth.Watch(stopCh); err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Use("/mutate", RequireClient)
+	app.Get("/mutate", func(c *fiber.Ctx) error {
+		return c.SendString("ok")
+	})
+	app.Get("/healthz", func(c *fiber.Ctx) error {
+		return c.SendString("ok")
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serving := newAuthority(t, "serving-ca").issue(t, "webhook")
+	go func() {
+		_ = app.Listener(tls.NewListener(ln, auth.Configure(&tls.Config{Certificates: []tls.Certificate{serving}})))
+	}()
+	defer func() {
+		_ = app.Shutdown()
+	}()
+
+	get := func(path string, certs ...tls.Certificate) (int, error) {
+		client := &http.Client{Transport: &http.Transport{
+			DisableKeepAlives: true,
+			// #nosec G402 the serving certificate isn't under test
+			TLSClientConfig: &tls.Config{Certificates: certs, InsecureSkipVerify: true},
+		}}
+		res, err := client.Get("https://" + ln.Addr().String() + path)
+		if err != nil {
+			return 0, err
+		}
+		res.Body.Close()
+		return res.StatusCode, nil
+	}
+
+	tt := []struct {
+		name       string
+		path       string
+		certs      []tls.Certificate
+		statusCode int
+		rejected   bool
+	}{
+		{
+			name:       "common name",
+			path:       "/mutate",
+			certs:      []tls.Certificate{clientCA.issue(t, "kube-apiserver")},
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "san",
+			path:       "/mutate",
+			certs:      []tls.Certificate{clientCA.issue(t, "other", "apiserver.example.com")},
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "no client certificate",
+			path:       "/mutate",
+			statusCode: http.StatusUnauthorized,
+		},
+		{
+			name:       "no client certificate health",
+			path:       "/healthz",
+			statusCode: http.StatusOK,
+		},
+		{
+			name:     "not permitted",
+			path:     "/mutate",
+			certs:    []tls.Certificate{clientCA.issue(t, "other")},
+			rejected: true,
+		},
+		{
+			name:     "untrusted",
+			path:     "/mutate",
+			certs:    []tls.Certificate{otherCA.issue(t, "kube-apiserver")},
+			rejected: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			statusCode, err := get(tc.path, tc.certs...)
+			// the handshake fails or, when the client has no certificate issued by an acceptable CA, it sends none
+			if tc.rejected && err == nil && statusCode != http.StatusUnauthorized {
+				t.Errorf("expected rejection, got status code %d", statusCode)
+			}
+			if tc.rejected {
+				return
+			}
+			if err != nil {
+				t.Errorf("expected status code %d, got error %s", tc.statusCode, err)
+			}
+			if statusCode != tc.statusCode {
+				t.Errorf("expected status code %d, got %d", tc.statusCode, statusCode)
+			}
+		})
+	}
+
+	t.Run("reload", func(t *testing.T) {
+		writeBundle(t, bundle, otherCA.pem)
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			statusCode, err := get("/mutate", otherCA.issue(t, "kube-apiserver"))
+			if err == nil && statusCode == http.StatusOK {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected reloaded client CA bundle to be trusted, got %d %v", statusCode, err)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		if statusCode, err := get("/mutate", clientCA.issue(t, "kube-apiserver")); err == nil && statusCode != http.StatusUnauthorized {
+			t.Errorf("expected rejection for replaced client CA bundle, got status code %d", statusCode)
+		}
+	})
+}
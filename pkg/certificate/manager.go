@@ -0,0 +1,321 @@
+package certificate
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"defaultallowpe/pkg/config"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Secret keys holding the self-managed certificates
+const (
+	CACertKey = "ca.crt"
+	CAKeyKey  = "ca.key"
+)
+
+// reconcileInterval is how often the self-managed certificates are checked for rotation
+const reconcileInterval = 10 * time.Minute
+
+// Manager generates a CA and serving certificate, stores them in a Secret, rotates them before they expire and
+// injects the CA bundle into the webhook configurations
+type Manager struct {
+	client kubernetes.Interface
+	config config.SelfManaged
+	log    *zap.SugaredLogger
+	now    func() time.Time
+
+	cert atomic.Value
+}
+
+// NewManager creates a self-managed certificate manager
+func NewManager(client kubernetes.Interface, config config.SelfManaged, log *zap.SugaredLogger) *Manager {
+	return &Manager{
+		client: client,
+		config: config,
+		log:    log,
+		now:    time.Now,
+	}
+}
+
+// Start reconciles the certificates until stopped
+func (m *Manager) Start(stopCh <-chan struct{}) {
+	go wait.Until(func() {
+		if err := m.Reconcile(context.Background()); err != nil {
+			m.log.Warnw("unable to reconcile self-managed certificates",
+				"err", err,
+			)
+		}
+	}, reconcileInterval, stopCh)
+}
+
+// GetCertificate returns the current serving certificate, it can be used as the GetCertificate member of a tls.Config
+func (m *Manager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert, _ := m.cert.Load().(*tls.Certificate)
+	if cert == nil {
+		return nil, errors.New("self-managed certificate not issued yet")
+	}
+	return cert, nil
+}
+
+// DNSNames of the webhook Service
+func (m *Manager) DNSNames() []string {
+	service := m.config.Service
+	namespace := m.config.Namespace
+	return []string{
+		service,
+		fmt.Sprintf("%s.%s", service, namespace),
+		fmt.Sprintf("%s.%s.svc", service, namespace),
+		fmt.Sprintf("%s.%s.svc.cluster.local", service, namespace),
+	}
+}
+
+// Reconcile issues or rotates the certificates stored in the Secret as needed, serves them and injects the CA bundle
+func (m *Manager) Reconcile(ctx context.Context) error {
+	// replicas starting or rotating together race to write the Secret, the losers reconcile against the stored certificates
+	err := m.reconcile(ctx)
+	if apierrors.IsAlreadyExists(err) || apierrors.IsConflict(err) {
+		m.log.Debugw("self-managed certificate secret changed concurrently, reconciling again",
+			"secret", m.config.Namespace+"/"+m.config.Secret,
+		)
+		err = m.reconcile(ctx)
+	}
+	return err
+}
+
+func (m *Manager) reconcile(ctx context.Context) error {
+	secrets := m.client.CoreV1().Secrets(m.config.Namespace)
+	secret, err := secrets.Get(ctx, m.config.Secret, metav1.GetOptions{})
+	notFound := apierrors.IsNotFound(err)
+	if err != nil && !notFound {
+		return err
+	}
+	if notFound {
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      m.config.Secret,
+				Namespace: m.config.Namespace,
+			},
+			Type: corev1.SecretTypeTLS,
+		}
+	}
+
+	data, reason, err := m.ensure(secret.Data)
+	if err != nil {
+		return err
+	}
+	if reason != "" {
+		secret.Data = data
+		if notFound {
+			_, err = secrets.Create(ctx, secret, metav1.CreateOptions{})
+		} else {
+			_, err = secrets.Update(ctx, secret, metav1.UpdateOptions{})
+		}
+		if err != nil {
+			return err
+		}
+		m.log.Infow("self-managed certificate issued",
+			"secret", m.config.Namespace+"/"+m.config.Secret,
+			"reason", reason,
+		)
+	}
+
+	cert, err := tls.X509KeyPair(data[corev1.TLSCertKey], data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		return err
+	}
+	m.cert.Store(&cert)
+	return m.injectCABundle(ctx, data[CACertKey])
+}
+
+// ensure checks the CA and serving certificate, describing why they had to be issued
+func (m *Manager) ensure(data map[string][]byte) (map[string][]byte, string, error) {
+	now := m.now()
+	next := map[string][]byte{}
+	for key, value := range data {
+		next[key] = value
+	}
+
+	// the CA must outlive every serving certificate it signs
+	var reason string
+	ca, caKey, err := parseKeyPair(data[CACertKey], data[CAKeyKey])
+	switch {
+	case err != nil:
+		reason = "CA missing or invalid"
+	case ca.NotAfter.Before(now.Add(m.config.Validity + m.config.RotateBefore)):
+		reason = "CA expires soon"
+	}
+	if reason != "" {
+		previous := ca
+		if ca, caKey, err = issue(nil, nil, pkix.Name{CommonName: m.config.Service + "-ca"}, nil, now, config.SelfManagedCAValidity); err != nil {
+			return nil, "", err
+		}
+		next[CACertKey], next[CAKeyKey] = encode(ca, caKey)
+		// keep trusting the previous CA until it expires, its serving certificate may still be in use
+		if previous != nil && previous.NotAfter.After(now) {
+			next[CACertKey] = append(next[CACertKey], pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: previous.Raw})...)
+		}
+	}
+
+	serving, _, err := parseKeyPair(data[corev1.TLSCertKey], data[corev1.TLSPrivateKeyKey])
+	switch {
+	case reason != "":
+	case err != nil:
+		reason = "serving certificate missing or invalid"
+	case serving.CheckSignatureFrom(ca) != nil:
+		reason = "serving certificate not signed by CA"
+	case serving.NotAfter.Before(now.Add(m.config.RotateBefore)):
+		reason = "serving certificate expires soon"
+	case !equal(serving.DNSNames, m.DNSNames()):
+		reason = "serving certificate DNS names changed"
+	default:
+		return data, "", nil
+	}
+	serving, servingKey, err := issue(ca, caKey, pkix.Name{CommonName: m.DNSNames()[2]}, m.DNSNames(), now, m.config.Validity)
+	if err != nil {
+		return nil, "", err
+	}
+	next[corev1.TLSCertKey], next[corev1.TLSPrivateKeyKey] = encode(serving, servingKey)
+	return next, reason, nil
+}
+
+// injectCABundle sets the CA bundle of every webhook in the webhook configurations
+func (m *Manager) injectCABundle(ctx context.Context, caBundle []byte) error {
+	if name := m.config.MutatingWebhookConfiguration; name != "" {
+		configurations := m.client.AdmissionregistrationV1().MutatingWebhookConfigurations()
+		configuration, err := configurations.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		changed := false
+		for i := range configuration.Webhooks {
+			if !bytes.Equal(configuration.Webhooks[i].ClientConfig.CABundle, caBundle) {
+				configuration.Webhooks[i].ClientConfig.CABundle = caBundle
+				changed = true
+			}
+		}
+		if changed {
+			if _, err := configurations.Update(ctx, configuration, metav1.UpdateOptions{}); err != nil {
+				return err
+			}
+			m.log.Infow("CA bundle injected",
+				"mutatingWebhookConfiguration", name,
+			)
+		}
+	}
+	if name := m.config.ValidatingWebhookConfiguration; name != "" {
+		configurations := m.client.AdmissionregistrationV1().ValidatingWebhookConfigurations()
+		configuration, err := configurations.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		changed := false
+		for i := range configuration.Webhooks {
+			if !bytes.Equal(configuration.Webhooks[i].ClientConfig.CABundle, caBundle) {
+				configuration.Webhooks[i].ClientConfig.CABundle = caBundle
+				changed = true
+			}
+		}
+		if changed {
+			if _, err := configurations.Update(ctx, configuration, metav1.UpdateOptions{}); err != nil {
+				return err
+			}
+			m.log.Infow("CA bundle injected",
+				"validatingWebhookConfiguration", name,
+			)
+		}
+	}
+	return nil
+}
+
+// parseKeyPair parses the first certificate of a PEM bundle and its EC private key
+func parseKeyPair(certPEM, keyPEM []byte) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	certBlock, _ := pem.Decode(certPEM)
+	keyBlock, _ := pem.Decode(keyPEM)
+	if certBlock == nil || keyBlock == nil {
+		return nil, nil, errors.New("missing PEM block")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+// issue creates a certificate signed by the CA, or a self-signed CA when no CA is given
+func issue(ca *x509.Certificate, caKey crypto.Signer, subject pkix.Name, dnsNames []string, now time.Time, validity time.Duration) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      subject,
+		DNSNames:     dnsNames,
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ca == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+		template.ExtKeyUsage = nil
+		ca, caKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+// encode PEM encodes a certificate and its private key
+func encode(cert *x509.Certificate, key *ecdsa.PrivateKey) ([]byte, []byte) {
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,203 @@
+package certificate
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"defaultallowpe/pkg/config"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var selfManaged = config.SelfManaged{
+	Enabled:                        true,
+	Namespace:                      "default-allow-privilege-escalation",
+	Secret:                         "webhook-server-cert",
+	Service:                        "webhook",
+	MutatingWebhookConfiguration:   "default-allow-privilege-escalation",
+	ValidatingWebhookConfiguration: "default-allow-privilege-escalation",
+	Validity:                       365 * 24 * time.Hour,
+	RotateBefore:                   30 * 24 * time.Hour,
+}
+
+func webhookConfigurations() (*admissionregistrationv1.MutatingWebhookConfiguration, *admissionregistrationv1.ValidatingWebhookConfiguration) {
+	meta := metav1.ObjectMeta{Name: "default-allow-privilege-escalation"}
+	return &admissionregistrationv1.MutatingWebhookConfiguration{
+			ObjectMeta: meta,
+			Webhooks:   []admissionregistrationv1.MutatingWebhook{{Name: "default-allow-privilege-escalation.webhook.marshallford.me"}},
+		}, &admissionregistrationv1.ValidatingWebhookConfiguration{
+			ObjectMeta: meta,
+			Webhooks:   []admissionregistrationv1.ValidatingWebhook{{Name: "validate.default-allow-privilege-escalation.webhook.marshallford.me"}},
+		}
+}
+
+// served verifies the served certificate against the CA bundle, returning its leaf
+func served(t *testing.T, manager *Manager, caBundle []byte) *x509.Certificate {
+	cert, err := manager.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := Leaf(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caBundle) {
+		t.Fatal("expected CA bundle, got none")
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		DNSName:     "webhook.default-allow-privilege-escalation.svc",
+		Roots:       roots,
+		CurrentTime: manager.now(),
+	}); err != nil {
+		t.Errorf("expected served certificate to verify, got %s", err)
+	}
+	return leaf
+}
+
+func TestManager(t *testing.T) {
+	ctx := context.Background()
+	mutating, validating := webhookConfigurations()
+	client := fake.NewSimpleClientset(mutating, validating)
+	now := time.Now()
+	manager := NewManager(client, selfManaged, zap.NewNop().Sugar())
+	manager.now = func() time.Time { return now }
+
+	if _, err := manager.GetCertificate(nil); err == nil {
+		t.Error("expected error before reconcile, got none")
+	}
+
+	// issue
+	if err := manager.Reconcile(ctx); err != nil {
+		t.Fatal(err)
+	}
+	secret, err := client.CoreV1().Secrets(selfManaged.Namespace).Get(ctx, selfManaged.Secret, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Type != corev1.SecretTypeTLS {
+		t.Errorf("expected secret type %s, got %s", corev1.SecretTypeTLS, secret.Type)
+	}
+	caBundle := secret.Data[CACertKey]
+	mutating, _ = client.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(ctx, selfManaged.MutatingWebhookConfiguration, metav1.GetOptions{})
+	if !bytes.Equal(mutating.Webhooks[0].ClientConfig.CABundle, caBundle) {
+		t.Error("expected mutating webhook caBundle to be injected")
+	}
+	validating, _ = client.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(ctx, selfManaged.ValidatingWebhookConfiguration, metav1.GetOptions{})
+	if !bytes.Equal(validating.Webhooks[0].ClientConfig.CABundle, caBundle) {
+		t.Error("expected validating webhook caBundle to be injected")
+	}
+	issued := served(t, manager, caBundle)
+
+	// unchanged while valid
+	if err := manager.Reconcile(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if leaf := served(t, manager, caBundle); !bytes.Equal(leaf.Raw, issued.Raw) {
+		t.Error("expected serving certificate to be unchanged")
+	}
+
+	// rotate before expiry, keeping the CA
+	now = issued.NotAfter.Add(-selfManaged.RotateBefore / 2)
+	if err := manager.Reconcile(ctx); err != nil {
+		t.Fatal(err)
+	}
+	secret, _ = client.CoreV1().Secrets(selfManaged.Namespace).Get(ctx, selfManaged.Secret, metav1.GetOptions{})
+	if !bytes.Equal(secret.Data[CACertKey], caBundle) {
+		t.Error("expected CA to be unchanged")
+	}
+	if leaf := served(t, manager, caBundle); bytes.Equal(leaf.Raw, issued.Raw) {
+		t.Error("expected serving certificate to be rotated")
+	}
+}
+
+func TestManagerInvalidSecret(t *testing.T) {
+	ctx := context.Background()
+	mutating, validating := webhookConfigurations()
+	client := fake.NewSimpleClientset(mutating, validating, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      selfManaged.Secret,
+			Namespace: selfManaged.Namespace,
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       []byte("invalid"),
+			corev1.TLSPrivateKeyKey: []byte("invalid"),
+		},
+	})
+	manager := NewManager(client, selfManaged, zap.NewNop().Sugar())
+	if err := manager.Reconcile(ctx); err != nil {
+		t.Fatal(err)
+	}
+	secret, _ := client.CoreV1().Secrets(selfManaged.Namespace).Get(ctx, selfManaged.Secret, metav1.GetOptions{})
+	served(t, manager, secret.Data[CACertKey])
+}
+
+func TestManagerCARotation(t *testing.T) {
+	ctx := context.Background()
+	mutating, validating := webhookConfigurations()
+	client := fake.NewSimpleClientset(mutating, validating)
+	now := time.Now()
+	manager := NewManager(client, selfManaged, zap.NewNop().Sugar())
+	manager.now = func() time.Time { return now }
+	if err := manager.Reconcile(ctx); err != nil {
+		t.Fatal(err)
+	}
+	secret, _ := client.CoreV1().Secrets(selfManaged.Namespace).Get(ctx, selfManaged.Secret, metav1.GetOptions{})
+	previous := secret.Data[CACertKey]
+
+	now = now.Add(config.SelfManagedCAValidity - selfManaged.Validity)
+	if err := manager.Reconcile(ctx); err != nil {
+		t.Fatal(err)
+	}
+	secret, _ = client.CoreV1().Secrets(selfManaged.Namespace).Get(ctx, selfManaged.Secret, metav1.GetOptions{})
+	caBundle := secret.Data[CACertKey]
+	if !bytes.HasSuffix(caBundle, previous) || bytes.Equal(caBundle, previous) {
+		t.Error("expected CA bundle with the new CA followed by the previous CA")
+	}
+	served(t, manager, caBundle)
+}
+
+func TestManagerSecretAlreadyExists(t *testing.T) {
+	ctx := context.Background()
+	mutating, validating := webhookConfigurations()
+	client := fake.NewSimpleClientset(mutating, validating)
+
+	// another replica creates the Secret between the get and the create
+	data, _, err := NewManager(nil, selfManaged, zap.NewNop().Sugar()).ensure(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raced := false
+	client.PrependReactor("create", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if raced {
+			return false, nil, nil
+		}
+		raced = true
+		secret := action.(k8stesting.CreateAction).GetObject().(*corev1.Secret).DeepCopy()
+		secret.Data = data
+		if err := client.Tracker().Add(secret); err != nil {
+			t.Fatal(err)
+		}
+		return true, nil, apierrors.NewAlreadyExists(corev1.Resource("secrets"), secret.Name)
+	})
+
+	manager := NewManager(client, selfManaged, zap.NewNop().Sugar())
+	if err := manager.Reconcile(ctx); err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	secret, _ := client.CoreV1().Secrets(selfManaged.Namespace).Get(ctx, selfManaged.Secret, metav1.GetOptions{})
+	if !bytes.Equal(secret.Data[CACertKey], data[CACertKey]) || !bytes.Equal(secret.Data[corev1.TLSCertKey], data[corev1.TLSCertKey]) {
+		t.Error("expected certificates of the other replica to be kept")
+	}
+	served(t, manager, data[CACertKey])
+}
@@ -1,14 +1,245 @@
 package config
 
 import (
+	"errors"
+	"fmt"
+	"path/filepath"
 	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/viper"
 )
 
-// New creates a webhook config
-func New() (*viper.Viper, error) {
-	c := map[string]interface{}{
+// Config is the typed webhook config
+type Config struct {
+	ConfigPath string      `mapstructure:"configPath"`
+	Logging    Logging     `mapstructure:"logging"`
+	Server     Server      `mapstructure:"server"`
+	Metrics    Metrics     `mapstructure:"metrics"`
+	Namespaces Namespaces  `mapstructure:"namespaces"`
+	Requesters Requesters  `mapstructure:"exempt"`
+	Accounts   Accounts    `mapstructure:"serviceAccounts"`
+	Policies   Policies    `mapstructure:"policies"`
+	Overrides  Overrides   `mapstructure:"overrides"`
+	Validate   Validation  `mapstructure:"validate"`
+	Images     []ImageRule `mapstructure:"images"`
+	Defaulters Defaulters  `mapstructure:"defaulters"`
+	Profiles   Profiles    `mapstructure:"profiles"`
+	App        App         `mapstructure:"app"`
+}
+
+// Logging config
+type Logging struct {
+	Level string `mapstructure:"level"`
+}
+
+// Server config
+type Server struct {
+	Port     int      `mapstructure:"port"`
+	TLS      TLS      `mapstructure:"tls"`
+	Shutdown Shutdown `mapstructure:"shutdown"`
+}
+
+// TLS config for the webhook server
+type TLS struct {
+	Enabled  bool   `mapstructure:"enabled"`
+	Dir      string `mapstructure:"dir"`
+	CertFile string `mapstructure:"certFile"`
+	KeyFile  string `mapstructure:"keyFile"`
+	// ExpiryWarning is how long before the certificate expires to start logging warnings
+	ExpiryWarning time.Duration `mapstructure:"expiryWarning"`
+	// ClientCA enables mutual TLS, verifying client certificates against the bundle
+	ClientCA          string      `mapstructure:"clientCA"`
+	ClientCommonNames []string    `mapstructure:"clientCommonNames"`
+	ClientSANs        []string    `mapstructure:"clientSANs"`
+	SelfManaged       SelfManaged `mapstructure:"selfManaged"`
+	// TLS parameters, see TLSConfig
+	MinVersion       string   `mapstructure:"minVersion"`
+	MaxVersion       string   `mapstructure:"maxVersion"`
+	CipherSuites     []string `mapstructure:"cipherSuites"`
+	CurvePreferences []string `mapstructure:"curvePreferences"`
+	NextProtos       []string `mapstructure:"nextProtos"`
+}
+
+// SelfManagedCAValidity is how long a self-managed CA is valid for, it must outlive every serving certificate it signs
+const SelfManagedCAValidity = 10 * 365 * 24 * time.Hour
+
+// SelfManaged config for serving certificates issued by the webhook itself instead of being read from the TLS dir
+type SelfManaged struct {
+	Enabled                        bool          `mapstructure:"enabled"`
+	Namespace                      string        `mapstructure:"namespace"`
+	Secret                         string        `mapstructure:"secret"`
+	Service                        string        `mapstructure:"service"`
+	MutatingWebhookConfiguration   string        `mapstructure:"mutatingWebhookConfiguration"`
+	ValidatingWebhookConfiguration string        `mapstructure:"validatingWebhookConfiguration"`
+	Validity                       time.Duration `mapstructure:"validity"`
+	RotateBefore                   time.Duration `mapstructure:"rotateBefore"`
+}
+
+// Path resolves a TLS file relative to the TLS dir
+func (t TLS) Path(file string) string {
+	if filepath.IsAbs(file) {
+		return file
+	}
+	return filepath.Join(t.Dir, file)
+}
+
+// Shutdown config, readiness fails for the drain period before in-flight requests are given the timeout to complete
+type Shutdown struct {
+	Drain   time.Duration `mapstructure:"drain"`
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+// Metrics config
+type Metrics struct {
+	Enabled bool `mapstructure:"enabled"`
+	Port    int  `mapstructure:"port"`
+}
+
+// Namespaces config controlling which namespaces are mutated
+type Namespaces struct {
+	Include []string `mapstructure:"include"`
+	Exclude []string `mapstructure:"exclude"`
+	Lookup  bool     `mapstructure:"lookup"`
+}
+
+// Policies config
+type Policies struct {
+	Enabled        bool          `mapstructure:"enabled"`
+	StatusInterval time.Duration `mapstructure:"statusInterval"`
+}
+
+// Overrides config
+type Overrides struct {
+	Enabled    bool     `mapstructure:"enabled"`
+	Namespaces []string `mapstructure:"namespaces"`
+}
+
+// Validation config for the validating webhook
+type Validation struct {
+	Mode   string `mapstructure:"mode"`
+	Exempt Exempt `mapstructure:"exempt"`
+}
+
+// Exempt config for the validating webhook
+type Exempt struct {
+	Namespaces      []string `mapstructure:"namespaces"`
+	ServiceAccounts []string `mapstructure:"serviceAccounts"`
+	Images          []string `mapstructure:"images"`
+}
+
+// Requesters config for admission requests exempt from mutation, matched against the requesting user
+type Requesters struct {
+	Users           []string `mapstructure:"users"`
+	Groups          []string `mapstructure:"groups"`
+	ServiceAccounts []string `mapstructure:"serviceAccounts"`
+}
+
+// Accounts config for the default of pods running as matching service accounts
+type Accounts struct {
+	Names   []string `mapstructure:"names"`
+	Default string   `mapstructure:"default"`
+}
+
+// Defaulters config for securityContext fields other than allowPrivilegeEscalation
+type Defaulters struct {
+	RunAsNonRoot           BoolDefaulter   `mapstructure:"runAsNonRoot"`
+	ReadOnlyRootFilesystem BoolDefaulter   `mapstructure:"readOnlyRootFilesystem"`
+	DropCapabilities       ListDefaulter   `mapstructure:"dropCapabilities"`
+	SeccompProfile         StringDefaulter `mapstructure:"seccompProfile"`
+	ProcMount              StringDefaulter `mapstructure:"procMount"`
+}
+
+// ImageRule config, a container image matching every set field gets the default
+type ImageRule struct {
+	Name       string `mapstructure:"name"`
+	Registry   string `mapstructure:"registry"`
+	Repository string `mapstructure:"repository"`
+	Tag        string `mapstructure:"tag"`
+	Digest     string `mapstructure:"digest"`
+	Default    string `mapstructure:"default"`
+}
+
+// ID identifies the rule by name or position
+func (r ImageRule) ID(index int) string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return fmt.Sprintf("images[%d]", index)
+}
+
+// Profiles config
+type Profiles struct {
+	Restricted Profile `mapstructure:"restricted"`
+}
+
+// Profile config
+type Profile struct {
+	Enabled bool `mapstructure:"enabled"`
+}
+
+// BoolDefaulter config
+type BoolDefaulter struct {
+	Enabled bool `mapstructure:"enabled"`
+	Value   bool `mapstructure:"value"`
+}
+
+// StringDefaulter config
+type StringDefaulter struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Value   string `mapstructure:"value"`
+}
+
+// ListDefaulter config
+type ListDefaulter struct {
+	Enabled bool     `mapstructure:"enabled"`
+	Value   []string `mapstructure:"value"`
+}
+
+// App config
+type App struct {
+	Mode     string `mapstructure:"mode"`
+	Default  bool   `mapstructure:"default"`
+	Conflict string `mapstructure:"conflict"`
+}
+
+// Snapshot publishes the latest valid config, safe for concurrent use
+type Snapshot struct {
+	value atomic.Value
+	v     *viper.Viper
+}
+
+// NewSnapshot creates a snapshot holding a config
+func NewSnapshot(c *Config) *Snapshot {
+	s := &Snapshot{}
+	s.Store(c)
+	return s
+}
+
+// Load returns the current config, which must not be modified
+func (s *Snapshot) Load() *Config {
+	return s.value.Load().(*Config)
+}
+
+// Store publishes a new config
+func (s *Snapshot) Store(c *Config) {
+	s.value.Store(c)
+}
+
+// Validate checks the current config is still valid, such as the TLS files still being present
+func (s *Snapshot) Validate() error {
+	c, ok := s.value.Load().(*Config)
+	if !ok || c == nil {
+		return errors.New("no config loaded")
+	}
+	return c.validate()
+}
+
+// defaults are the config values used when not set by the config file or environment
+func defaults() map[string]interface{} {
+	return map[string]interface{}{
 		"configPath": ".",
 		"logging": map[string]interface{}{
 			"level": "info",
@@ -20,26 +251,142 @@ func New() (*viper.Viper, error) {
 				"dir":      "/run/secrets/tls",
 				"certFile": "tls.crt",
 				"keyFile":  "tls.key",
+				// cert-manager renews certificates 30 days before expiry by default
+				"expiryWarning":     "336h",
+				"clientCA":          "",
+				"clientCommonNames": []string{},
+				"clientSANs":        []string{},
+				"minVersion":        "1.2",
+				"maxVersion":        "",
+				"cipherSuites":      []string{},
+				"curvePreferences":  []string{},
+				"nextProtos":        []string{},
+				"selfManaged": map[string]interface{}{
+					"enabled":                        false,
+					"namespace":                      "",
+					"secret":                         "webhook-server-cert",
+					"service":                        "webhook",
+					"mutatingWebhookConfiguration":   "default-allow-privilege-escalation",
+					"validatingWebhookConfiguration": "default-allow-privilege-escalation",
+					"validity":                       "8760h",
+					"rotateBefore":                   "720h",
+				},
+			},
+			"shutdown": map[string]interface{}{
+				"drain":   "5s",
+				"timeout": "20s",
+			},
+		},
+		"metrics": map[string]interface{}{
+			"enabled": true,
+			"port":    9090,
+		},
+		"namespaces": map[string]interface{}{
+			"include": []string{},
+			"exclude": []string{"kube-system", "kube-public"},
+			"lookup":  false,
+		},
+		"policies": map[string]interface{}{
+			"enabled":        false,
+			"statusInterval": "30s",
+		},
+		"overrides": map[string]interface{}{
+			"enabled":    true,
+			"namespaces": []string{},
+		},
+		"validate": map[string]interface{}{
+			"mode": "warn",
+			"exempt": map[string]interface{}{
+				"namespaces":      []string{"kube-system"},
+				"serviceAccounts": []string{},
+				"images":          []string{},
+			},
+		},
+		"exempt": map[string]interface{}{
+			"users":           []string{},
+			"groups":          []string{},
+			"serviceAccounts": []string{},
+		},
+		"serviceAccounts": map[string]interface{}{
+			"names":   []string{},
+			"default": "true",
+		},
+		"images": []interface{}{},
+		"defaulters": map[string]interface{}{
+			"runAsNonRoot": map[string]interface{}{
+				"enabled": false,
+				"value":   true,
+			},
+			"readOnlyRootFilesystem": map[string]interface{}{
+				"enabled": false,
+				"value":   true,
+			},
+			"dropCapabilities": map[string]interface{}{
+				"enabled": false,
+				"value":   []string{"ALL"},
+			},
+			"seccompProfile": map[string]interface{}{
+				"enabled": false,
+				"value":   "RuntimeDefault",
+			},
+			"procMount": map[string]interface{}{
+				"enabled": false,
+				"value":   "Default",
+			},
+		},
+		"profiles": map[string]interface{}{
+			"restricted": map[string]interface{}{
+				"enabled": false,
 			},
 		},
 		"app": map[string]interface{}{
-			"default": false,
+			"mode":     "enforce",
+			"default":  false,
+			"conflict": "skip",
 		},
 	}
+}
+
+// newViper creates a viper instance with the defaults and environment overrides
+func newViper() *viper.Viper {
 	v := viper.New()
-	for key, value := range c {
+	for key, value := range defaults() {
 		v.SetDefault(key, value)
 	}
 	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	v.AutomaticEnv()
+	return v
+}
+
+// load decodes and validates the config values
+func load(v *viper.Viper) (*Config, error) {
+	c := &Config{}
+	if err := v.Unmarshal(c, func(dc *mapstructure.DecoderConfig) {
+		dc.ErrorUnused = true
+	}); err != nil {
+		return nil, err
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// New creates a webhook config snapshot
+func New() (*Snapshot, error) {
+	v := newViper()
 	v.AddConfigPath(v.GetString("configPath"))
 	v.SetConfigName("config")
 	if err := v.ReadInConfig(); err != nil {
 		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
 			return nil, err
 		}
-		return v, nil
 	}
-	v.WatchConfig()
-	return v, nil
+	c, err := load(v)
+	if err != nil {
+		return nil, err
+	}
+	s := NewSnapshot(c)
+	s.v = v
+	return s, nil
 }
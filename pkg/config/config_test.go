@@ -4,6 +4,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -34,8 +35,30 @@ func TestNewConfig(t *testing.T) {
 	os.Setenv("SERVER_PORT", "1")
 	defer os.Unsetenv("SERVER_PORT")
 	config, _ := New()
-	port := config.GetInt("server.port")
+	port := config.Load().Server.Port
 	if port != 1 {
 		t.Errorf("expected port %d, got %d", 1, port)
 	}
 }
+
+func TestNewConfigUnknownKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Setenv("CONFIGPATH", dir)
+	defer os.Unsetenv("CONFIGPATH")
+
+	tmpConfig := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(tmpConfig, []byte("app:\n  defualt: true\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = New()
+	expected := "'app' has invalid keys: defualt"
+	if err == nil || !strings.Contains(err.Error(), expected) {
+		t.Errorf("expected error containing %s, got %v", expected, err)
+	}
+}
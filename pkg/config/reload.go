@@ -0,0 +1,118 @@
+package config
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+)
+
+// startupKeys are only read on startup, their values are kept until the webhook restarts
+var startupKeys = []string{"server.", "metrics.", "policies.", "namespaces.lookup"}
+
+// startupKey checks if a key is only read on startup
+func startupKey(key string) bool {
+	for _, prefix := range startupKeys {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// OnReload watches the config file, publishing the new config and calling apply with the changed keys when valid
+// or calling reject when invalid, in which case the last good config is kept. Keys only read on startup keep their
+// startup values, apply is given those that differ from startup as restart.
+func (s *Snapshot) OnReload(apply func(changed []string, restart []string), reject func(err error)) error {
+	if s.v == nil || s.v.ConfigFileUsed() == "" {
+		return nil
+	}
+	file := s.v.ConfigFileUsed()
+	last, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	previous := settings(s.v)
+	startup := previous
+	s.v.OnConfigChange(func(e fsnotify.Event) {
+		next, err := ioutil.ReadFile(file)
+		if err != nil {
+			reject(err)
+			return
+		}
+		if bytes.Equal(next, last) {
+			return
+		}
+		v, c, err := loadFile(file, next)
+		if err != nil {
+			reject(err)
+			return
+		}
+		running := s.Load()
+		c.Server, c.Metrics, c.Policies = running.Server, running.Metrics, running.Policies
+		c.Namespaces.Lookup = running.Namespaces.Lookup
+		s.Store(c)
+		last = next
+		current := settings(v)
+		var changed, restart []string
+		for _, key := range Diff(previous, current) {
+			if !startupKey(key) {
+				changed = append(changed, key)
+			}
+		}
+		for _, key := range Diff(startup, current) {
+			if startupKey(key) {
+				restart = append(restart, key)
+			}
+		}
+		previous = current
+		apply(changed, restart)
+	})
+	s.v.WatchConfig()
+	return nil
+}
+
+// loadFile decodes and validates the contents of a config file as if it were loaded in place of the current one
+func loadFile(file string, contents []byte) (*viper.Viper, *Config, error) {
+	v := newViper()
+	v.SetConfigType(strings.TrimPrefix(filepath.Ext(file), "."))
+	if err := v.ReadConfig(bytes.NewReader(contents)); err != nil {
+		return nil, nil, err
+	}
+	c, err := load(v)
+	if err != nil {
+		return nil, nil, err
+	}
+	return v, c, nil
+}
+
+// settings flattens the config values by key
+func settings(v *viper.Viper) map[string]interface{} {
+	s := map[string]interface{}{}
+	for _, key := range v.AllKeys() {
+		s[key] = v.Get(key)
+	}
+	return s
+}
+
+// Diff lists the keys that were added, removed or changed between two sets of flattened settings
+func Diff(previous, next map[string]interface{}) []string {
+	var changed []string
+	for key, value := range next {
+		if old, ok := previous[key]; !ok || !reflect.DeepEqual(old, value) {
+			changed = append(changed, key)
+		}
+	}
+	for key := range previous {
+		if _, ok := next[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
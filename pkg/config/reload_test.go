@@ -0,0 +1,122 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// writeConfig replaces the config file in a single step, like a ConfigMap update
+func writeConfig(t *testing.T, file, contents string) {
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(contents), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	previous := map[string]interface{}{
+		"logging.level": "info",
+		"app.default":   false,
+		"app.mode":      "enforce",
+	}
+	next := map[string]interface{}{
+		"logging.level": "debug",
+		"app.default":   false,
+		"app.conflict":  "skip",
+	}
+	expected := []string{"app.conflict", "app.mode", "logging.level"}
+	changed := Diff(previous, next)
+	if !reflect.DeepEqual(changed, expected) {
+		t.Errorf("expected changed keys %v, got %v", expected, changed)
+	}
+}
+
+func TestOnReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Setenv("CONFIGPATH", dir)
+	defer os.Unsetenv("CONFIGPATH")
+
+	tmpConfig := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(tmpConfig, []byte("logging:\n  level: info\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	applied := make(chan []string, 1)
+	restarts := make(chan []string, 1)
+	rejected := make(chan error, 1)
+	if err := config.OnReload(func(changed []string, restart []string) {
+		applied <- changed
+		restarts <- restart
+	}, func(err error) {
+		rejected <- err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	writeConfig(t, tmpConfig, "logging:\n  level: verbose\n")
+	select {
+	case <-rejected:
+	case changed := <-applied:
+		t.Fatalf("expected rejected reload, got changed keys %v", changed)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected rejected reload, got none")
+	}
+	if level := config.Load().Logging.Level; level != "info" {
+		t.Errorf("expected level %s, got %s", "info", level)
+	}
+
+	writeConfig(t, tmpConfig, "logging:\n  level: debug\n")
+	select {
+	case changed := <-applied:
+		expected := []string{"logging.level"}
+		if !reflect.DeepEqual(changed, expected) {
+			t.Errorf("expected changed keys %v, got %v", expected, changed)
+		}
+	case err := <-rejected:
+		t.Fatalf("expected applied reload, got %s", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected applied reload, got none")
+	}
+	if level := config.Load().Logging.Level; level != "debug" {
+		t.Errorf("expected level %s, got %s", "debug", level)
+	}
+	if restart := <-restarts; len(restart) != 0 {
+		t.Errorf("expected no keys requiring a restart, got %v", restart)
+	}
+
+	// keys read on startup keep their values
+	writeConfig(t, tmpConfig, "logging:\n  level: debug\nserver:\n  port: 9443\n")
+	select {
+	case changed := <-applied:
+		if len(changed) != 0 {
+			t.Errorf("expected no changed keys, got %v", changed)
+		}
+		expected := []string{"server.port"}
+		if restart := <-restarts; !reflect.DeepEqual(restart, expected) {
+			t.Errorf("expected keys requiring a restart %v, got %v", expected, restart)
+		}
+	case err := <-rejected:
+		t.Fatalf("expected applied reload, got %s", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected applied reload, got none")
+	}
+	if port := config.Load().Server.Port; port != 8443 {
+		t.Errorf("expected port %d, got %d", 8443, port)
+	}
+}
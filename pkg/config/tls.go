@@ -0,0 +1,153 @@
+package config
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// tlsVersions are the names of the supported TLS versions
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsCurves are the names of the supported elliptic curves
+var tlsCurves = map[string]tls.CurveID{
+	"X25519": tls.X25519,
+	"P256":   tls.CurveP256,
+	"P384":   tls.CurveP384,
+	"P521":   tls.CurveP521,
+}
+
+// nextProtoHTTP1 is the only ALPN protocol served, fasthttp does not implement HTTP/2
+const nextProtoHTTP1 = "http/1.1"
+
+// TLSConfig builds the server tls.Config from the TLS parameters, certificates are left to the caller
+func (t TLS) TLSConfig() (*tls.Config, error) {
+	var errs []string
+	c := &tls.Config{}
+
+	for key, value := range map[string]struct {
+		name    string
+		version *uint16
+	}{
+		"server.tls.minVersion": {t.MinVersion, &c.MinVersion},
+		"server.tls.maxVersion": {t.MaxVersion, &c.MaxVersion},
+	} {
+		if value.name == "" {
+			continue
+		}
+		version, ok := tlsVersions[value.name]
+		if !ok {
+			errs = append(errs, fmt.Sprintf("%s: expected one of 1.0, 1.1, 1.2, 1.3, got %q", key, value.name))
+			continue
+		}
+		*value.version = version
+	}
+	if c.MinVersion != 0 && c.MaxVersion != 0 && c.MinVersion > c.MaxVersion {
+		errs = append(errs, fmt.Sprintf("server.tls.minVersion: %s is greater than maxVersion %s", t.MinVersion, t.MaxVersion))
+	}
+
+	suites := map[string]uint16{}
+	tls13 := map[string]bool{}
+	for _, suite := range tls.CipherSuites() {
+		suites[suite.Name] = suite.ID
+		// crypto/tls ignores CipherSuites for TLS 1.3
+		tls13[suite.Name] = len(suite.SupportedVersions) == 1 && suite.SupportedVersions[0] == tls.VersionTLS13
+	}
+	insecure := map[string]bool{}
+	for _, suite := range tls.InsecureCipherSuites() {
+		insecure[suite.Name] = true
+	}
+	for _, name := range t.CipherSuites {
+		id, ok := suites[name]
+		switch {
+		case insecure[name]:
+			errs = append(errs, fmt.Sprintf("server.tls.cipherSuites: %s is insecure", name))
+		case tls13[name]:
+			errs = append(errs, fmt.Sprintf("server.tls.cipherSuites: %s is a TLS 1.3 cipher suite, TLS 1.3 cipher suites are fixed", name))
+		case !ok:
+			errs = append(errs, fmt.Sprintf("server.tls.cipherSuites: unknown cipher suite %q", name))
+		default:
+			c.CipherSuites = append(c.CipherSuites, id)
+		}
+	}
+	if len(t.CipherSuites) > 0 && c.MinVersion == tls.VersionTLS13 {
+		errs = append(errs, "server.tls.cipherSuites: not configurable with minVersion 1.3, TLS 1.3 cipher suites are fixed")
+	}
+
+	for _, name := range t.CurvePreferences {
+		curve, ok := tlsCurves[name]
+		if !ok {
+			errs = append(errs, fmt.Sprintf("server.tls.curvePreferences: expected one of X25519, P256, P384, P521, got %q", name))
+			continue
+		}
+		c.CurvePreferences = append(c.CurvePreferences, curve)
+	}
+
+	for _, proto := range t.NextProtos {
+		switch proto {
+		case nextProtoHTTP1:
+			c.NextProtos = append(c.NextProtos, proto)
+		case "h2":
+			errs = append(errs, "server.tls.nextProtos: h2 is not supported, the webhook server only serves HTTP/1.1")
+		default:
+			errs = append(errs, fmt.Sprintf("server.tls.nextProtos: expected %s, got %q", nextProtoHTTP1, proto))
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.New(strings.Join(errs, "; "))
+	}
+	return c, nil
+}
+
+// LogFields lists the effective TLS parameters as log fields
+func (t TLS) LogFields() []interface{} {
+	c, err := t.TLSConfig()
+	if err != nil {
+		return []interface{}{"err", err}
+	}
+	versionName := func(version uint16, fallback string) string {
+		for name, v := range tlsVersions {
+			if v == version {
+				return name
+			}
+		}
+		return fallback
+	}
+	cipherSuites := []string{}
+	for _, id := range c.CipherSuites {
+		cipherSuites = append(cipherSuites, tls.CipherSuiteName(id))
+	}
+	if len(cipherSuites) == 0 {
+		cipherSuites = append(cipherSuites, "default")
+	}
+	curves := []string{}
+	for _, curve := range c.CurvePreferences {
+		for name, id := range tlsCurves {
+			if id == curve {
+				curves = append(curves, name)
+			}
+		}
+	}
+	if len(curves) == 0 {
+		curves = append(curves, "default")
+	}
+	nextProtos := c.NextProtos
+	if len(nextProtos) == 0 {
+		nextProtos = []string{nextProtoHTTP1}
+	}
+	return []interface{}{
+		"minVersion", versionName(c.MinVersion, "default"),
+		"maxVersion", versionName(c.MaxVersion, "1.3"),
+		"cipherSuites", cipherSuites,
+		"curvePreferences", curves,
+		"nextProtos", nextProtos,
+		"clientCertificates", t.ClientCA != "",
+	}
+}
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"crypto/tls"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTLSConfig(t *testing.T) {
+	tt := []struct {
+		name     string
+		tls      TLS
+		expected *tls.Config
+		error    string
+	}{
+		{
+			name:     "defaults",
+			tls:      TLS{MinVersion: "1.2"},
+			expected: &tls.Config{MinVersion: tls.VersionTLS12},
+		},
+		{
+			name: "parameters",
+			tls: TLS{
+				MinVersion:       "1.2",
+				MaxVersion:       "1.3",
+				CipherSuites:     []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"},
+				CurvePreferences: []string{"X25519", "P256"},
+				NextProtos:       []string{"http/1.1"},
+			},
+			expected: &tls.Config{
+				MinVersion:       tls.VersionTLS12,
+				MaxVersion:       tls.VersionTLS13,
+				CipherSuites:     []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384},
+				CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+				NextProtos:       []string{"http/1.1"},
+			},
+		},
+		{
+			name:     "tls 1.3 only",
+			tls:      TLS{MinVersion: "1.3"},
+			expected: &tls.Config{MinVersion: tls.VersionTLS13},
+		},
+		{
+			name:  "unknown version",
+			tls:   TLS{MinVersion: "1.4"},
+			error: `server.tls.minVersion: expected one of 1.0, 1.1, 1.2, 1.3, got "1.4"`,
+		},
+		{
+			name:  "min greater than max",
+			tls:   TLS{MinVersion: "1.3", MaxVersion: "1.2"},
+			error: "server.tls.minVersion: 1.3 is greater than maxVersion 1.2",
+		},
+		{
+			name:  "unknown cipher suite",
+			tls:   TLS{CipherSuites: []string{"TLS_FOO"}},
+			error: `server.tls.cipherSuites: unknown cipher suite "TLS_FOO"`,
+		},
+		{
+			name:  "insecure cipher suite",
+			tls:   TLS{CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}},
+			error: "server.tls.cipherSuites: TLS_RSA_WITH_RC4_128_SHA is insecure",
+		},
+		{
+			name:  "tls 1.3 cipher suite",
+			tls:   TLS{CipherSuites: []string{"TLS_AES_128_GCM_SHA256"}},
+			error: "server.tls.cipherSuites: TLS_AES_128_GCM_SHA256 is a TLS 1.3 cipher suite, TLS 1.3 cipher suites are fixed",
+		},
+		{
+			name:  "cipher suites with tls 1.3",
+			tls:   TLS{MinVersion: "1.3", CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"}},
+			error: "server.tls.cipherSuites: not configurable with minVersion 1.3",
+		},
+		{
+			name:  "unknown curve",
+			tls:   TLS{CurvePreferences: []string{"P224"}},
+			error: `server.tls.curvePreferences: expected one of X25519, P256, P384, P521, got "P224"`,
+		},
+		{
+			name:  "http2",
+			tls:   TLS{NextProtos: []string{"h2", "http/1.1"}},
+			error: "server.tls.nextProtos: h2 is not supported, the webhook server only serves HTTP/1.1",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := tc.tls.TLSConfig()
+			if tc.error != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.error) {
+					t.Errorf("expected error containing %s, got %v", tc.error, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %s", err)
+			}
+			if !reflect.DeepEqual(c, tc.expected) {
+				t.Errorf("expected %+v, got %+v", tc.expected, c)
+			}
+		})
+	}
+}
+
+func TestTLSLogFields(t *testing.T) {
+	fields := TLS{MinVersion: "1.3"}.LogFields()
+	expected := []interface{}{
+		"minVersion", "1.3",
+		"maxVersion", "1.3",
+		"cipherSuites", []string{"default"},
+		"curvePreferences", []string{"default"},
+		"nextProtos", []string{"http/1.1"},
+		"clientCertificates", false,
+	}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Errorf("expected %v, got %v", expected, fields)
+	}
+}
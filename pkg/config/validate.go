@@ -0,0 +1,195 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+)
+
+const portUpperBound = 65535
+
+// Permitted values of enumerated config keys
+var (
+	appModes      = []string{"enforce", "warn", "audit"}
+	appConflicts  = []string{"skip", "allow", "deny"}
+	validateModes = []string{"warn", "deny"}
+	seccompTypes  = []string{"RuntimeDefault", "Unconfined"}
+	procMounts    = []string{"Default", "Unmasked"}
+	defaultValues = []string{"true", "false", "skip"}
+)
+
+// validate checks the config values, describing every invalid key
+func (c *Config) validate() error {
+	var errs []string
+
+	var level zapcore.Level
+	if err := level.UnmarshalText([]byte(c.Logging.Level)); err != nil {
+		errs = append(errs, fmt.Sprintf("logging.level: %s", err))
+	}
+	for key, port := range map[string]int{"server.port": c.Server.Port, "metrics.port": c.Metrics.Port} {
+		if port < 1 || port > portUpperBound {
+			errs = append(errs, fmt.Sprintf("%s: expected port between 1 and %d, got %d", key, portUpperBound, port))
+		}
+	}
+	if c.Server.TLS.Enabled {
+		files := map[string]string{}
+		if !c.Server.TLS.SelfManaged.Enabled {
+			files["server.tls.certFile"] = c.Server.TLS.CertFile
+			files["server.tls.keyFile"] = c.Server.TLS.KeyFile
+		}
+		if c.Server.TLS.ClientCA != "" {
+			files["server.tls.clientCA"] = c.Server.TLS.ClientCA
+		}
+		for key, file := range files {
+			if _, err := os.Stat(c.Server.TLS.Path(file)); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s", key, err))
+			}
+		}
+	}
+	for key, value := range map[string]struct {
+		value   string
+		choices []string
+	}{
+		"app.mode":                        {c.App.Mode, appModes},
+		"app.conflict":                    {c.App.Conflict, appConflicts},
+		"validate.mode":                   {c.Validate.Mode, validateModes},
+		"serviceAccounts.default":         {c.Accounts.Default, defaultValues},
+		"defaulters.seccompProfile.value": {c.Defaulters.SeccompProfile.Value, seccompTypes},
+		"defaulters.procMount.value":      {c.Defaulters.ProcMount.Value, procMounts},
+	} {
+		if !contains(value.choices, value.value) {
+			errs = append(errs, fmt.Sprintf("%s: expected one of %s, got %q", key, strings.Join(value.choices, ", "), value.value))
+		}
+	}
+	if _, err := c.Server.TLS.TLSConfig(); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if selfManaged := c.Server.TLS.SelfManaged; selfManaged.Enabled {
+		if !c.Server.TLS.Enabled {
+			errs = append(errs, "server.tls.selfManaged.enabled: requires server.tls.enabled")
+		}
+		for key, value := range map[string]string{
+			"server.tls.selfManaged.namespace": selfManaged.Namespace,
+			"server.tls.selfManaged.secret":    selfManaged.Secret,
+			"server.tls.selfManaged.service":   selfManaged.Service,
+		} {
+			if value == "" {
+				errs = append(errs, fmt.Sprintf("%s: required when self-managed certificates are enabled", key))
+			}
+		}
+		if selfManaged.RotateBefore <= 0 || selfManaged.RotateBefore >= selfManaged.Validity {
+			errs = append(errs, fmt.Sprintf("server.tls.selfManaged.rotateBefore: expected positive duration less than validity %s, got %s", selfManaged.Validity, selfManaged.RotateBefore))
+		}
+		// otherwise the CA would be reissued on every reconcile
+		if selfManaged.Validity+selfManaged.RotateBefore >= SelfManagedCAValidity {
+			errs = append(errs, fmt.Sprintf("server.tls.selfManaged.validity: expected validity plus rotateBefore less than the CA validity %s, got %s", SelfManagedCAValidity, selfManaged.Validity+selfManaged.RotateBefore))
+		}
+	}
+	if c.Server.TLS.ExpiryWarning < 0 {
+		errs = append(errs, fmt.Sprintf("server.tls.expiryWarning: expected non-negative duration, got %s", c.Server.TLS.ExpiryWarning))
+	}
+	if c.Defaulters.DropCapabilities.Enabled {
+		if len(c.Defaulters.DropCapabilities.Value) == 0 {
+			errs = append(errs, "defaulters.dropCapabilities.value: expected at least one capability")
+		}
+		for _, capability := range c.Defaulters.DropCapabilities.Value {
+			if strings.TrimSpace(capability) == "" {
+				errs = append(errs, "defaulters.dropCapabilities.value: expected non-empty capability names")
+				break
+			}
+		}
+	}
+	for key, patterns := range map[string][]string{
+		"namespaces.include":              c.Namespaces.Include,
+		"namespaces.exclude":              c.Namespaces.Exclude,
+		"exempt.users":                    c.Requesters.Users,
+		"exempt.groups":                   c.Requesters.Groups,
+		"exempt.serviceAccounts":          c.Requesters.ServiceAccounts,
+		"serviceAccounts.names":           c.Accounts.Names,
+		"overrides.namespaces":            c.Overrides.Namespaces,
+		"validate.exempt.namespaces":      c.Validate.Exempt.Namespaces,
+		"validate.exempt.serviceAccounts": c.Validate.Exempt.ServiceAccounts,
+		"validate.exempt.images":          c.Validate.Exempt.Images,
+		"server.tls.clientCommonNames":    c.Server.TLS.ClientCommonNames,
+		"server.tls.clientSANs":           c.Server.TLS.ClientSANs,
+	} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: invalid pattern %q", key, pattern))
+			}
+		}
+	}
+	for i, rule := range c.Images {
+		errs = append(errs, rule.validate(fmt.Sprintf("images[%d]", i))...)
+	}
+	// the validating webhook would deny the allowPrivilegeEscalation true set by the mutating webhook
+	if c.Validate.Mode == "deny" {
+		escalating := map[string]bool{
+			"app.default":             c.App.Default,
+			"app.conflict":            c.App.Conflict == "allow",
+			"serviceAccounts.default": c.Accounts.Default == "true" && len(c.Accounts.Names) > 0,
+		}
+		for i, rule := range c.Images {
+			escalating[fmt.Sprintf("images[%d].default", i)] = rule.Default == "true"
+		}
+		for key, value := range escalating {
+			if value {
+				errs = append(errs, fmt.Sprintf("%s: sets allowPrivilegeEscalation to true, which validate.mode deny rejects", key))
+			}
+		}
+	}
+	if c.Profiles.Restricted.Enabled && !c.Namespaces.Lookup {
+		errs = append(errs, "profiles.restricted.enabled: requires namespaces.lookup")
+	}
+	if c.Server.Shutdown.Drain < 0 {
+		errs = append(errs, fmt.Sprintf("server.shutdown.drain: expected non-negative duration, got %s", c.Server.Shutdown.Drain))
+	}
+	if c.Server.Shutdown.Timeout <= 0 {
+		errs = append(errs, fmt.Sprintf("server.shutdown.timeout: expected positive duration, got %s", c.Server.Shutdown.Timeout))
+	}
+	if c.Policies.StatusInterval <= 0 {
+		errs = append(errs, fmt.Sprintf("policies.statusInterval: expected positive duration, got %s", c.Policies.StatusInterval))
+	}
+
+	if len(errs) > 0 {
+		// maps are iterated in random order
+		sort.Strings(errs)
+		return fmt.Errorf("invalid config: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// validate checks an image rule, key is its position in the config
+func (r ImageRule) validate(key string) []string {
+	var errs []string
+	if !contains(defaultValues, r.Default) {
+		errs = append(errs, fmt.Sprintf("%s.default: expected one of %s, got %q", key, strings.Join(defaultValues, ", "), r.Default))
+	}
+	if r.Registry == "" && r.Repository == "" && r.Tag == "" && r.Digest == "" {
+		errs = append(errs, fmt.Sprintf("%s: expected at least one of registry, repository, tag or digest", key))
+	}
+	for field, pattern := range map[string]string{
+		"registry":   r.Registry,
+		"repository": r.Repository,
+		"tag":        r.Tag,
+		"digest":     r.Digest,
+	} {
+		if _, err := path.Match(pattern, ""); err != nil {
+			errs = append(errs, fmt.Sprintf("%s.%s: invalid pattern %q", key, field, pattern))
+		}
+	}
+	return errs
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,181 @@
+package config
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tt := []struct {
+		name  string
+		env   map[string]string
+		error string
+	}{
+		{
+			name: "defaults",
+		},
+		{
+			name:  "log level",
+			env:   map[string]string{"LOGGING_LEVEL": "verbose"},
+			error: "logging.level",
+		},
+		{
+			name:  "port",
+			env:   map[string]string{"SERVER_PORT": "70000"},
+			error: "server.port: expected port between 1 and 65535, got 70000",
+		},
+		{
+			name:  "bool",
+			env:   map[string]string{"APP_DEFAULT": "maybe"},
+			error: "app.default",
+		},
+		{
+			name:  "tls",
+			env:   map[string]string{"SERVER_TLS_ENABLED": "true", "SERVER_TLS_DIR": "/nonexistent"},
+			error: "server.tls.certFile: stat /nonexistent/tls.crt: no such file or directory",
+		},
+		{
+			name:  "self-managed",
+			env:   map[string]string{"SERVER_TLS_ENABLED": "true", "SERVER_TLS_SELFMANAGED_ENABLED": "true"},
+			error: "server.tls.selfManaged.namespace: required when self-managed certificates are enabled",
+		},
+		{
+			name:  "self-managed validity",
+			env:   map[string]string{"SERVER_TLS_ENABLED": "true", "SERVER_TLS_SELFMANAGED_ENABLED": "true", "SERVER_TLS_SELFMANAGED_NAMESPACE": "default", "SERVER_TLS_SELFMANAGED_VALIDITY": "87600h"},
+			error: "server.tls.selfManaged.validity: expected validity plus rotateBefore less than the CA validity 87600h0m0s, got 88320h0m0s",
+		},
+		{
+			name:  "mode",
+			env:   map[string]string{"APP_MODE": "dryrun"},
+			error: `app.mode: expected one of enforce, warn, audit, got "dryrun"`,
+		},
+		{
+			name:  "seccomp profile",
+			env:   map[string]string{"DEFAULTERS_SECCOMPPROFILE_VALUE": "Localhost"},
+			error: `defaulters.seccompProfile.value: expected one of RuntimeDefault, Unconfined, got "Localhost"`,
+		},
+		{
+			name:  "drop capabilities",
+			env:   map[string]string{"DEFAULTERS_DROPCAPABILITIES_ENABLED": "true", "DEFAULTERS_DROPCAPABILITIES_VALUE": " "},
+			error: "defaulters.dropCapabilities.value: expected non-empty capability names",
+		},
+		{
+			name:  "restricted profile",
+			env:   map[string]string{"PROFILES_RESTRICTED_ENABLED": "true", "NAMESPACES_LOOKUP": "false"},
+			error: "profiles.restricted.enabled: requires namespaces.lookup",
+		},
+		{
+			name:  "service account default",
+			env:   map[string]string{"SERVICEACCOUNTS_DEFAULT": "maybe"},
+			error: `serviceAccounts.default: expected one of true, false, skip, got "maybe"`,
+		},
+		{
+			name:  "deny default",
+			env:   map[string]string{"VALIDATE_MODE": "deny", "APP_DEFAULT": "true"},
+			error: "app.default: sets allowPrivilegeEscalation to true, which validate.mode deny rejects",
+		},
+		{
+			name:  "deny conflict",
+			env:   map[string]string{"VALIDATE_MODE": "deny", "APP_CONFLICT": "allow"},
+			error: "app.conflict: sets allowPrivilegeEscalation to true, which validate.mode deny rejects",
+		},
+		{
+			name:  "deny service account default",
+			env:   map[string]string{"VALIDATE_MODE": "deny", "SERVICEACCOUNTS_NAMES": "monitoring/agent"},
+			error: "serviceAccounts.default: sets allowPrivilegeEscalation to true, which validate.mode deny rejects",
+		},
+		{
+			name: "deny",
+			env:  map[string]string{"VALIDATE_MODE": "deny", "SERVICEACCOUNTS_DEFAULT": "false", "SERVICEACCOUNTS_NAMES": "monitoring/agent"},
+		},
+		{
+			name:  "namespace pattern",
+			env:   map[string]string{"NAMESPACES_EXCLUDE": "[abc"},
+			error: `namespaces.exclude: invalid pattern "[abc"`,
+		},
+		{
+			name:  "exempt pattern",
+			env:   map[string]string{"EXEMPT_SERVICEACCOUNTS": "node-agent/[abc"},
+			error: `exempt.serviceAccounts: invalid pattern "node-agent/[abc"`,
+		},
+		{
+			name:  "shutdown",
+			env:   map[string]string{"SERVER_SHUTDOWN_DRAIN": "-1s"},
+			error: "server.shutdown.drain: expected non-negative duration, got -1s",
+		},
+		{
+			name:  "duration",
+			env:   map[string]string{"POLICIES_STATUSINTERVAL": "0s"},
+			error: "policies.statusInterval: expected positive duration, got 0s",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			for key, value := range tc.env {
+				os.Setenv(key, value)
+				defer os.Unsetenv(key)
+			}
+			_, err := load(newViper())
+			if tc.error == "" && err != nil {
+				t.Errorf("expected no error, got %s", err)
+			}
+			if tc.error != "" && (err == nil || !strings.Contains(err.Error(), tc.error)) {
+				t.Errorf("expected error containing %s, got %v", tc.error, err)
+			}
+		})
+	}
+}
+
+func TestValidateImageRules(t *testing.T) {
+	tt := []struct {
+		name  string
+		rule  map[string]interface{}
+		mode  string
+		error string
+	}{
+		{
+			name: "valid",
+			rule: map[string]interface{}{"registry": "registry.example.com", "repository": "vendor/*", "default": "true"},
+		},
+		{
+			name:  "default",
+			rule:  map[string]interface{}{"repository": "vendor/*", "default": "maybe"},
+			error: `images[0].default: expected one of true, false, skip, got "maybe"`,
+		},
+		{
+			name:  "matchers",
+			rule:  map[string]interface{}{"name": "everything", "default": "skip"},
+			error: "images[0]: expected at least one of registry, repository, tag or digest",
+		},
+		{
+			name:  "deny",
+			rule:  map[string]interface{}{"repository": "vendor/*", "default": "true"},
+			mode:  "deny",
+			error: "images[0].default: sets allowPrivilegeEscalation to true, which validate.mode deny rejects",
+		},
+		{
+			name:  "pattern",
+			rule:  map[string]interface{}{"tag": "[", "default": "skip"},
+			error: `images[0].tag: invalid pattern "["`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			v := newViper()
+			v.Set("images", []interface{}{tc.rule})
+			if tc.mode != "" {
+				v.Set("validate.mode", tc.mode)
+			}
+			_, err := load(v)
+			if tc.error == "" && err != nil {
+				t.Errorf("expected no error, got %s", err)
+			}
+			if tc.error != "" && (err == nil || !strings.Contains(err.Error(), tc.error)) {
+				t.Errorf("expected error containing %s, got %v", tc.error, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package health
+
+import (
+	"crypto/tls"
+	"defaultallowpe/pkg/certificate"
+	"defaultallowpe/pkg/config"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// checker adapts a func to the Checker interface
+type checker struct {
+	name  string
+	check func() error
+}
+
+func (c checker) Name() string {
+	return c.name
+}
+
+func (c checker) Check() error {
+	return c.check()
+}
+
+// NewChecker creates a named checker from a func
+func NewChecker(name string, check func() error) Checker {
+	return checker{name: name, check: check}
+}
+
+// Name of the shutdown check
+func (s *State) Name() string {
+	return "shutdown"
+}
+
+// Check fails once the webhook is shutting down
+func (s *State) Check() error {
+	if s.ShuttingDown() {
+		return errors.New("shutting down")
+	}
+	return nil
+}
+
+// Config checks the current config snapshot is still valid
+func Config(snapshot *config.Snapshot) Checker {
+	return NewChecker("config", snapshot.Validate)
+}
+
+// Certificate checks a serving certificate is present and within its validity period
+func Certificate(get func() (*tls.Certificate, error)) Checker {
+	return NewChecker("certificate", func() error {
+		cert, err := get()
+		if err != nil {
+			return err
+		}
+		leaf, err := certificate.Leaf(cert)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		if now.After(leaf.NotAfter) {
+			return fmt.Errorf("certificate expired at %s", leaf.NotAfter.UTC().Format(time.RFC3339))
+		}
+		if now.Before(leaf.NotBefore) {
+			return fmt.Errorf("certificate not valid until %s", leaf.NotBefore.UTC().Format(time.RFC3339))
+		}
+		return nil
+	})
+}
+
+// Synced checks informer caches have synced
+func Synced(name string, synced ...func() bool) Checker {
+	return NewChecker(name, func() error {
+		for _, s := range synced {
+			if !s() {
+				return errors.New("informer cache not synced")
+			}
+		}
+		return nil
+	})
+}
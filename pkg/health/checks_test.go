@@ -0,0 +1,93 @@
+package health
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"defaultallowpe/pkg/config"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+func selfSigned(t *testing.T, notBefore, notAfter time.Time) *tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "webhook"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestCertificate(t *testing.T) {
+	now := time.Now()
+	tt := []struct {
+		name  string
+		cert  *tls.Certificate
+		error string
+	}{
+		{
+			name: "valid",
+			cert: selfSigned(t, now.Add(-time.Hour), now.Add(time.Hour)),
+		},
+		{
+			name:  "missing",
+			error: "no certificate loaded",
+		},
+		{
+			name:  "expired",
+			cert:  selfSigned(t, now.Add(-2*time.Hour), now.Add(-time.Hour)),
+			error: "certificate expired at",
+		},
+		{
+			name:  "not yet valid",
+			cert:  selfSigned(t, now.Add(time.Hour), now.Add(2*time.Hour)),
+			error: "certificate not valid until",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Certificate(func() (*tls.Certificate, error) {
+				return tc.cert, nil
+			}).Check()
+			if tc.error == "" && err != nil {
+				t.Errorf("expected no error, got %s", err)
+			}
+			if tc.error != "" && (err == nil || !strings.HasPrefix(err.Error(), tc.error)) {
+				t.Errorf("expected error %s, got %v", tc.error, err)
+			}
+		})
+	}
+}
+
+func TestConfig(t *testing.T) {
+	snapshot, _ := config.New()
+	if err := Config(snapshot).Check(); err != nil {
+		t.Errorf("expected no error, got %s", err)
+	}
+}
+
+func TestSynced(t *testing.T) {
+	synced := func() bool { return true }
+	notSynced := func() bool { return false }
+	if err := Synced("informers", synced, synced).Check(); err != nil {
+		t.Errorf("expected no error, got %s", err)
+	}
+	if err := Synced("informers", synced, notSynced).Check(); err == nil {
+		t.Error("expected error, got none")
+	}
+}
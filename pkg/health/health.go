@@ -1,8 +1,15 @@
 package health
 
 import (
+	"sync/atomic"
+
 	"github.com/gofiber/fiber/v2"
-	"github.com/spf13/viper"
+)
+
+// Check statuses
+const (
+	StatusOK     = "ok"
+	StatusFailed = "failed"
 )
 
 // Health model
@@ -10,14 +17,85 @@ type Health struct {
 	Ready bool `json:"ready"`
 }
 
+// Report model listing the status of each check
+type Report struct {
+	Status string        `json:"status"`
+	Checks []CheckReport `json:"checks"`
+}
+
+// CheckReport model
+type CheckReport struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Checker reports an error when a component is unhealthy
+type Checker interface {
+	Name() string
+	Check() error
+}
+
+// Checks are the checkers run for liveness and readiness
+type Checks struct {
+	Liveness  []Checker
+	Readiness []Checker
+}
+
+// State tracks whether the webhook is shutting down, safe for concurrent use
+type State struct {
+	shuttingDown int32
+}
+
+// ShutDown marks the webhook as shutting down
+func (s *State) ShutDown() {
+	atomic.StoreInt32(&s.shuttingDown, 1)
+}
+
+// ShuttingDown reports whether the webhook is shutting down
+func (s *State) ShuttingDown() bool {
+	return atomic.LoadInt32(&s.shuttingDown) == 1
+}
+
 // Routes manages Fiber routes for health pkg
-func Routes(r fiber.Router, config *viper.Viper) {
-	r.Get("/healthz", HandlerFunc(config))
+func Routes(r fiber.Router, checks Checks) {
+	r.Get("/livez", HandlerFunc(checks.Liveness))
+	r.Get("/readyz", HandlerFunc(checks.Readiness))
+	r.Get("/healthz", LegacyHandlerFunc(checks.Readiness))
+}
+
+// run runs every checker, reporting the status of each
+func run(checkers []Checker) Report {
+	report := Report{Status: StatusOK, Checks: []CheckReport{}}
+	for _, checker := range checkers {
+		check := CheckReport{Name: checker.Name(), Status: StatusOK}
+		if err := checker.Check(); err != nil {
+			check.Status = StatusFailed
+			check.Error = err.Error()
+			report.Status = StatusFailed
+		}
+		report.Checks = append(report.Checks, check)
+	}
+	return report
 }
 
 // HandlerFunc returns a func that is a HTTP handler for health requests
-func HandlerFunc(config *viper.Viper) fiber.Handler {
+func HandlerFunc(checkers []Checker) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		report := run(checkers)
+		if report.Status != StatusOK {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(report)
+		}
+		return c.JSON(report)
+	}
+}
+
+// LegacyHandlerFunc returns a func that is a HTTP handler for health requests, reporting only readiness
+func LegacyHandlerFunc(checkers []Checker) fiber.Handler {
 	return func(c *fiber.Ctx) error {
+		if run(checkers).Status != StatusOK {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(Health{Ready: false})
+		}
 		return c.JSON(Health{Ready: true})
 	}
 }
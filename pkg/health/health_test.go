@@ -1,8 +1,8 @@
 package health
 
 import (
-	"defaultallowpe/pkg/config"
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -14,9 +14,8 @@ import (
 func TestHealthApi(t *testing.T) {
 	req := httptest.NewRequest("GET", "/healthz", nil)
 
-	config, _ := config.New()
 	app := fiber.New()
-	Routes(app.Group(""), config)
+	Routes(app.Group(""), Checks{Readiness: []Checker{&State{}}})
 	res, _ := app.Test(req)
 
 	if res.StatusCode != http.StatusOK {
@@ -36,3 +35,99 @@ func TestHealthApi(t *testing.T) {
 		t.Error("expected ready true, got ready false")
 	}
 }
+
+func TestHealthApiChecks(t *testing.T) {
+	shuttingDown := &State{}
+	shuttingDown.ShutDown()
+	failing := NewChecker("failing", func() error {
+		return errors.New("broken")
+	})
+
+	tt := []struct {
+		name       string
+		path       string
+		checks     Checks
+		statusCode int
+		report     Report
+	}{
+		{
+			name:       "livez no checks",
+			path:       "/livez",
+			checks:     Checks{Readiness: []Checker{failing}},
+			statusCode: http.StatusOK,
+			report:     Report{Status: StatusOK, Checks: []CheckReport{}},
+		},
+		{
+			name:       "livez failing",
+			path:       "/livez",
+			checks:     Checks{Liveness: []Checker{failing}},
+			statusCode: http.StatusServiceUnavailable,
+			report: Report{Status: StatusFailed, Checks: []CheckReport{
+				{Name: "failing", Status: StatusFailed, Error: "broken"},
+			}},
+		},
+		{
+			name:       "readyz ready",
+			path:       "/readyz",
+			checks:     Checks{Readiness: []Checker{&State{}}},
+			statusCode: http.StatusOK,
+			report: Report{Status: StatusOK, Checks: []CheckReport{
+				{Name: "shutdown", Status: StatusOK},
+			}},
+		},
+		{
+			name:       "readyz shutting down",
+			path:       "/readyz",
+			checks:     Checks{Readiness: []Checker{shuttingDown, NewChecker("other", func() error { return nil })}},
+			statusCode: http.StatusServiceUnavailable,
+			report: Report{Status: StatusFailed, Checks: []CheckReport{
+				{Name: "shutdown", Status: StatusFailed, Error: "shutting down"},
+				{Name: "other", Status: StatusOK},
+			}},
+		},
+		{
+			name:       "healthz shutting down",
+			path:       "/healthz",
+			checks:     Checks{Readiness: []Checker{shuttingDown}},
+			statusCode: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tc.path, nil)
+
+			app := fiber.New()
+			Routes(app.Group(""), tc.checks)
+			res, _ := app.Test(req)
+
+			if res.StatusCode != tc.statusCode {
+				t.Errorf("expected status code %d, got %d", tc.statusCode, res.StatusCode)
+			}
+			if tc.report.Status == "" {
+				return
+			}
+
+			bodyBytes, err := ioutil.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			var report Report
+			err = json.Unmarshal(bodyBytes, &report)
+			if err != nil {
+				t.Fatal("failed to json decode res body")
+			}
+			if report.Status != tc.report.Status {
+				t.Errorf("expected status %s, got %s", tc.report.Status, report.Status)
+			}
+			if len(report.Checks) != len(tc.report.Checks) {
+				t.Fatalf("expected %d checks, got %d", len(tc.report.Checks), len(report.Checks))
+			}
+			for i, check := range report.Checks {
+				if check != tc.report.Checks[i] {
+					t.Errorf("expected check %+v, got %+v", tc.report.Checks[i], check)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "default_allow_privilege_escalation"
+
+// Outcomes of an admission
+const (
+	OutcomePatched = "patched"
+	OutcomeSkipped = "skipped"
+	OutcomeWarned  = "warned"
+	OutcomeAudited = "audited"
+	OutcomeAllowed = "allowed"
+	OutcomeDenied  = "denied"
+	OutcomeError   = "error"
+)
+
+// Results of a config reload
+const (
+	ReloadApplied  = "applied"
+	ReloadRejected = "rejected"
+)
+
+var (
+	// Registry holds the webhook metrics along with the Go and process collectors
+	Registry = prometheus.NewRegistry()
+
+	// Admissions counts admissions by webhook, namespace, operation and outcome
+	Admissions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "admissions_total",
+		Help:      "Admission reviews handled by webhook, namespace, operation and outcome.",
+	}, []string{"webhook", "namespace", "operation", "outcome"})
+
+	// ContainersDefaulted counts containers that had allowPrivilegeEscalation defaulted
+	ContainersDefaulted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "containers_defaulted_total",
+		Help:      "Containers with allowPrivilegeEscalation defaulted by namespace and value.",
+	}, []string{"namespace", "value"})
+
+	// AdmissionDuration observes the latency of the admission handlers
+	AdmissionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "admission_duration_seconds",
+		Help:      "Latency of admission review handlers.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"webhook"})
+
+	// ConfigReloads counts config file reloads by result
+	ConfigReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "config_reloads_total",
+		Help:      "Config file reloads by result.",
+	}, []string{"result"})
+
+	// CertificateNotAfter exposes the expiry of the served certificate by subject and DNS names
+	CertificateNotAfter = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "certificate_not_after_timestamp_seconds",
+		Help:      "Expiry of the served certificate as a Unix timestamp by subject and DNS names.",
+	}, []string{"subject", "dns_names"})
+)
+
+func init() {
+	Registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		Admissions,
+		ContainersDefaulted,
+		AdmissionDuration,
+		ConfigReloads,
+		CertificateNotAfter,
+	)
+}
+
+// Handler serves the metrics of the registry
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// Instrument wraps a Fiber handler, observing its latency
+func Instrument(webhook string, handler fiber.Handler) fiber.Handler {
+	observer := AdmissionDuration.WithLabelValues(webhook)
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		defer func() {
+			observer.Observe(time.Since(start).Seconds())
+		}()
+		return handler(c)
+	}
+}
@@ -0,0 +1,35 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestInstrument(t *testing.T) {
+	app := fiber.New()
+	app.Get("/instrumented", Instrument("test", func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	}))
+	if _, err := app.Test(httptest.NewRequest("GET", "/instrumented", nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected status code %d, got %d", http.StatusOK, rec.Code)
+	}
+	body, err := ioutil.ReadAll(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `default_allow_privilege_escalation_admission_duration_seconds_count{webhook="test"} 1`
+	if !strings.Contains(string(body), expected) {
+		t.Errorf("expected metrics to contain %s", expected)
+	}
+}
@@ -0,0 +1,120 @@
+package mutate
+
+import (
+	"defaultallowpe/pkg/admission"
+	"defaultallowpe/pkg/config"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// field is a securityContext field defaulted for a container
+type field struct {
+	name    string
+	value   interface{}
+	display string
+}
+
+// path is the JSON patch path of the field below the container's securityContext
+func (f field) path(basepath string) string {
+	return fmt.Sprintf("%v/%v", basepath, strings.ReplaceAll(f.name, ".", "/"))
+}
+
+// allowPrivilegeEscalation returns the allowPrivilegeEscalation field to default, resolving conflicts with privileged containers
+func allowPrivilegeEscalation(c admission.Container, value bool, opts options) (*field, string, error) {
+	sc := c.SecurityContext
+	if sc != nil && sc.AllowPrivilegeEscalation != nil {
+		return nil, "", nil
+	}
+
+	var warning string
+	if reason := conflict(sc); !value && reason != "" {
+		switch opts.conflictStrategy {
+		case ConflictAllow:
+			value = true
+			warning = fmt.Sprintf("%s %q %s, defaulted allowPrivilegeEscalation to true", c.Kind, c.Name, reason)
+		case ConflictDeny:
+			return nil, "", fmt.Errorf("%s %q %s and must explicitly set allowPrivilegeEscalation", c.Kind, c.Name, reason)
+		default:
+			return nil, fmt.Sprintf("%s %q %s, skipped defaulting allowPrivilegeEscalation", c.Kind, c.Name, reason), nil
+		}
+	}
+	return &field{name: "allowPrivilegeEscalation", value: value, display: fmt.Sprintf("%t", value)}, warning, nil
+}
+
+// defaulterFields returns the fields the enabled defaulters set on a container, only fields left nil by the container and pod are set
+func defaulterFields(c admission.Container, pod *corev1.PodSecurityContext, d config.Defaulters) ([]field, []string) {
+	sc := c.SecurityContext
+	if sc == nil {
+		sc = &corev1.SecurityContext{}
+	}
+	if pod == nil {
+		pod = &corev1.PodSecurityContext{}
+	}
+
+	var fields []field
+	var warnings []string
+	if d.RunAsNonRoot.Enabled && sc.RunAsNonRoot == nil && pod.RunAsNonRoot == nil {
+		runAsUser := sc.RunAsUser
+		if runAsUser == nil {
+			runAsUser = pod.RunAsUser
+		}
+		if d.RunAsNonRoot.Value && runAsUser != nil && *runAsUser == 0 {
+			warnings = append(warnings, fmt.Sprintf("%s %q runs as root, skipped defaulting runAsNonRoot", c.Kind, c.Name))
+		} else {
+			fields = append(fields, field{name: "runAsNonRoot", value: d.RunAsNonRoot.Value, display: fmt.Sprintf("%t", d.RunAsNonRoot.Value)})
+		}
+	}
+	if d.ReadOnlyRootFilesystem.Enabled && sc.ReadOnlyRootFilesystem == nil {
+		fields = append(fields, field{name: "readOnlyRootFilesystem", value: d.ReadOnlyRootFilesystem.Value, display: fmt.Sprintf("%t", d.ReadOnlyRootFilesystem.Value)})
+	}
+	if d.DropCapabilities.Enabled && (sc.Capabilities == nil || sc.Capabilities.Drop == nil) {
+		capabilities := make([]corev1.Capability, 0, len(d.DropCapabilities.Value))
+		for _, capability := range d.DropCapabilities.Value {
+			capabilities = append(capabilities, corev1.Capability(capability))
+		}
+		fields = append(fields, field{name: "capabilities.drop", value: capabilities, display: fmt.Sprintf("%v", d.DropCapabilities.Value)})
+	}
+	if d.SeccompProfile.Enabled && sc.SeccompProfile == nil && pod.SeccompProfile == nil {
+		fields = append(fields, field{name: "seccompProfile", value: corev1.SeccompProfile{Type: corev1.SeccompProfileType(d.SeccompProfile.Value)}, display: d.SeccompProfile.Value})
+	}
+	if d.ProcMount.Enabled && sc.ProcMount == nil {
+		fields = append(fields, field{name: "procMount", value: corev1.ProcMountType(d.ProcMount.Value), display: d.ProcMount.Value})
+	}
+	return fields, warnings
+}
+
+// patchContainer combines the fields defaulted for a container into patches, creating the parent objects that are missing
+func patchContainer(basepath string, c admission.Container, fields []field) []patch {
+	if len(fields) == 0 {
+		return nil
+	}
+
+	var patches []patch
+	sc := c.SecurityContext
+	if sc == nil {
+		patches = append(patches, patch{
+			Op:    "add",
+			Path:  basepath,
+			Value: corev1.SecurityContext{},
+		})
+	}
+	capabilities := sc != nil && sc.Capabilities != nil
+	for _, f := range fields {
+		if strings.HasPrefix(f.name, "capabilities.") && !capabilities {
+			patches = append(patches, patch{
+				Op:    "add",
+				Path:  fmt.Sprintf("%v/capabilities", basepath),
+				Value: corev1.Capabilities{},
+			})
+			capabilities = true
+		}
+		patches = append(patches, patch{
+			Op:    "add",
+			Path:  f.path(basepath),
+			Value: f.value,
+		})
+	}
+	return patches
+}
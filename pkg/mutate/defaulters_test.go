@@ -0,0 +1,175 @@
+package mutate
+
+import (
+	"bytes"
+	"defaultallowpe/pkg/config"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestMutateDefaulters(t *testing.T) {
+	root := int64(0)
+	enabled := true
+	defaulters := config.Defaulters{
+		RunAsNonRoot:           config.BoolDefaulter{Enabled: true, Value: true},
+		ReadOnlyRootFilesystem: config.BoolDefaulter{Enabled: true, Value: true},
+		DropCapabilities:       config.ListDefaulter{Enabled: true, Value: []string{"ALL"}},
+		SeccompProfile:         config.StringDefaulter{Enabled: true, Value: "RuntimeDefault"},
+		ProcMount:              config.StringDefaulter{Enabled: true, Value: "Default"},
+	}
+
+	tt := []struct {
+		name             string
+		input            corev1.Pod
+		defaulters       config.Defaulters
+		expected         []patch
+		expectedWarnings []string
+	}{
+		{
+			name:  "disabled",
+			input: pod("default", []corev1.Container{}, []corev1.Container{containerSecurityContextEmpty}),
+			expected: []patch{
+				{Op: "add", Path: "/spec/containers/0/securityContext/allowPrivilegeEscalation", Value: false},
+			},
+		},
+		{
+			name:       "all fields combined",
+			input:      pod("default", []corev1.Container{}, []corev1.Container{containerNoSecurityContext}),
+			defaulters: defaulters,
+			expected: []patch{
+				{Op: "add", Path: "/spec/containers/0/securityContext", Value: struct{}{}},
+				{Op: "add", Path: "/spec/containers/0/securityContext/allowPrivilegeEscalation", Value: false},
+				{Op: "add", Path: "/spec/containers/0/securityContext/runAsNonRoot", Value: true},
+				{Op: "add", Path: "/spec/containers/0/securityContext/readOnlyRootFilesystem", Value: true},
+				{Op: "add", Path: "/spec/containers/0/securityContext/capabilities", Value: struct{}{}},
+				{Op: "add", Path: "/spec/containers/0/securityContext/capabilities/drop", Value: []string{"ALL"}},
+				{Op: "add", Path: "/spec/containers/0/securityContext/seccompProfile", Value: map[string]string{"type": "RuntimeDefault"}},
+				{Op: "add", Path: "/spec/containers/0/securityContext/procMount", Value: "Default"},
+			},
+		},
+		{
+			name: "fields already set",
+			input: pod("default", []corev1.Container{}, []corev1.Container{{
+				Name:  "foo",
+				Image: "image:tag",
+				SecurityContext: &corev1.SecurityContext{
+					RunAsNonRoot:           &enabled,
+					ReadOnlyRootFilesystem: &enabled,
+					Capabilities:           &corev1.Capabilities{Drop: []corev1.Capability{"NET_RAW"}},
+					SeccompProfile:         &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeUnconfined},
+					ProcMount:              func() *corev1.ProcMountType { p := corev1.UnmaskedProcMount; return &p }(),
+				},
+			}}),
+			defaulters: defaulters,
+			expected: []patch{
+				{Op: "add", Path: "/spec/containers/0/securityContext/allowPrivilegeEscalation", Value: false},
+			},
+		},
+		{
+			name: "existing capabilities and pod security context",
+			input: func() corev1.Pod {
+				p := pod("default", []corev1.Container{}, []corev1.Container{{
+					Name:  "foo",
+					Image: "image:tag",
+					SecurityContext: &corev1.SecurityContext{
+						AllowPrivilegeEscalation: &enabled,
+						Capabilities:             &corev1.Capabilities{Add: []corev1.Capability{"NET_ADMIN"}},
+					},
+				}})
+				p.Spec.SecurityContext = &corev1.PodSecurityContext{
+					RunAsNonRoot:   &enabled,
+					SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+				}
+				return p
+			}(),
+			defaulters: config.Defaulters{
+				RunAsNonRoot:     defaulters.RunAsNonRoot,
+				DropCapabilities: defaulters.DropCapabilities,
+				SeccompProfile:   defaulters.SeccompProfile,
+			},
+			expected: []patch{
+				{Op: "add", Path: "/spec/containers/0/securityContext/capabilities/drop", Value: []string{"ALL"}},
+			},
+		},
+		{
+			name: "runs as root",
+			input: pod("default", []corev1.Container{}, []corev1.Container{{
+				Name:            "foo",
+				Image:           "image:tag",
+				SecurityContext: &corev1.SecurityContext{RunAsUser: &root},
+			}}),
+			defaulters: config.Defaulters{RunAsNonRoot: defaulters.RunAsNonRoot},
+			expected: []patch{
+				{Op: "add", Path: "/spec/containers/0/securityContext/allowPrivilegeEscalation", Value: false},
+			},
+			expectedWarnings: []string{`container "foo" runs as root, skipped defaulting runAsNonRoot`},
+		},
+		{
+			name:       "allowPrivilegeEscalation conflict skipped",
+			input:      pod("default", []corev1.Container{}, []corev1.Container{containerPrivileged}),
+			defaulters: config.Defaulters{ReadOnlyRootFilesystem: defaulters.ReadOnlyRootFilesystem},
+			expected: []patch{
+				{Op: "add", Path: "/spec/containers/0/securityContext/readOnlyRootFilesystem", Value: true},
+			},
+			expectedWarnings: []string{`container "foo" is privileged, skipped defaulting allowPrivilegeEscalation`},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			podBytes, err := json.Marshal(tc.input)
+			if err != nil {
+				t.Fatal("failed to json encode Pod")
+			}
+
+			admissionReview := admissionv1.AdmissionReview{}
+			admissionReview.TypeMeta = admissionReviewCreatePod.TypeMeta
+			admissionReview.Request = admissionReviewCreatePod.Request
+			admissionReview.Request.Object.Raw = podBytes
+			res, _ := mutate(&admissionReview, options{conflictStrategy: ConflictSkip, defaulters: tc.defaulters}, log)
+
+			expectedBytes, err := json.Marshal(tc.expected)
+			if err != nil {
+				t.Fatal("failed to json encode patch")
+			}
+			if !bytes.Equal(expectedBytes, res.Patch) {
+				t.Errorf("expected patch %s, got %s", expectedBytes, res.Patch)
+			}
+			if strings.Join(res.Warnings, "\n") != strings.Join(tc.expectedWarnings, "\n") {
+				t.Errorf("expected warnings %v, got %v", tc.expectedWarnings, res.Warnings)
+			}
+		})
+	}
+}
+
+func TestMutateDefaultersPredictions(t *testing.T) {
+	podBytes, err := json.Marshal(pod("default", []corev1.Container{}, []corev1.Container{containerSecurityContextEmpty}))
+	if err != nil {
+		t.Fatal("failed to json encode Pod")
+	}
+	admissionReview := admissionv1.AdmissionReview{}
+	admissionReview.TypeMeta = admissionReviewCreatePod.TypeMeta
+	admissionReview.Request = admissionReviewCreatePod.Request
+	admissionReview.Request.Object.Raw = podBytes
+
+	res, _ := mutate(&admissionReview, options{
+		mode: ModeWarn,
+		defaulters: config.Defaulters{
+			DropCapabilities: config.ListDefaulter{Enabled: true, Value: []string{"ALL"}},
+		},
+	}, log)
+	expected := []string{
+		`container "foo" would default allowPrivilegeEscalation to false`,
+		`container "foo" would default capabilities.drop to [ALL]`,
+	}
+	if strings.Join(res.Warnings, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected warnings %v, got %v", expected, res.Warnings)
+	}
+	if res.Patch != nil {
+		t.Errorf("expected no patch, got %s", res.Patch)
+	}
+}
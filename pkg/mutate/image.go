@@ -0,0 +1,113 @@
+package mutate
+
+import (
+	"defaultallowpe/pkg/admission"
+	"defaultallowpe/pkg/config"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ImageRulesAnnotation records the image rules that matched the containers of a pod
+const ImageRulesAnnotation = AnnotationPrefix + "image-rules"
+
+// defaultRegistry and officialRepository complete image references the way the container runtime does
+const (
+	defaultRegistry    = "docker.io"
+	officialRepository = "library/"
+	defaultTag         = "latest"
+)
+
+// reference is a parsed container image reference
+type reference struct {
+	registry   string
+	repository string
+	tag        string
+	digest     string
+}
+
+// parseReference splits an image into its registry, repository, tag and digest, applying the docker.io defaults
+func parseReference(image string) reference {
+	var r reference
+	name := image
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, r.digest = name[:i], name[i+1:]
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, r.tag = name[:i], name[i+1:]
+	}
+
+	// the first component is a registry when it looks like a host
+	r.registry, r.repository = defaultRegistry, name
+	if i := strings.Index(name, "/"); i >= 0 {
+		host := name[:i]
+		if strings.ContainsAny(host, ".:") || host == "localhost" {
+			r.registry, r.repository = host, name[i+1:]
+		}
+	}
+	if r.registry == defaultRegistry && !strings.Contains(r.repository, "/") {
+		r.repository = officialRepository + r.repository
+	}
+	if r.tag == "" && r.digest == "" {
+		r.tag = defaultTag
+	}
+	return r
+}
+
+// matches checks each field of an image rule that is set against the reference
+func (r reference) matches(rule config.ImageRule) bool {
+	for _, field := range [][2]string{
+		{rule.Registry, r.registry},
+		{rule.Repository, r.repository},
+		{rule.Tag, r.tag},
+		{rule.Digest, r.digest},
+	} {
+		if field[0] == "" {
+			continue
+		}
+		if matched, err := path.Match(field[0], field[1]); err != nil || !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// imageRule finds the first image rule matching a container and its default, rules have been validated with the config
+func imageRule(rules []config.ImageRule, c admission.Container) (decision, string, bool) {
+	r := parseReference(c.Image)
+	for i, rule := range rules {
+		if !r.matches(rule) {
+			continue
+		}
+		id := rule.ID(i)
+		d, err := parseDecision(rule.Default, fmt.Sprintf("image rule %s", id))
+		return d, id, err == nil
+	}
+	return decision{}, "", false
+}
+
+// escapePointer escapes a JSON pointer reference token
+func escapePointer(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
+
+// annotateImageRules records the matched image rules on the pod (template) metadata
+func annotateImageRules(pt *admission.PodTemplate, matched []string) []patch {
+	if len(matched) == 0 {
+		return nil
+	}
+	metadata := fmt.Sprintf("%s/metadata", strings.TrimSuffix(pt.Path, "/spec"))
+	var patches []patch
+	if pt.Template.Annotations == nil {
+		patches = append(patches, patch{
+			Op:    "add",
+			Path:  fmt.Sprintf("%s/annotations", metadata),
+			Value: struct{}{},
+		})
+	}
+	return append(patches, patch{
+		Op:    "add",
+		Path:  fmt.Sprintf("%s/annotations/%s", metadata, escapePointer(ImageRulesAnnotation)),
+		Value: strings.Join(matched, ","),
+	})
+}
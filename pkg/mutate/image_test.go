@@ -0,0 +1,167 @@
+package mutate
+
+import (
+	"bytes"
+	"defaultallowpe/pkg/config"
+	"encoding/json"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseReference(t *testing.T) {
+	tt := []struct {
+		image    string
+		expected reference
+	}{
+		{image: "busybox", expected: reference{registry: "docker.io", repository: "library/busybox", tag: "latest"}},
+		{image: "vendor/ping:1.2", expected: reference{registry: "docker.io", repository: "vendor/ping", tag: "1.2"}},
+		{image: "registry.example.com:5000/team/app:v1", expected: reference{registry: "registry.example.com:5000", repository: "team/app", tag: "v1"}},
+		{image: "localhost/app@sha256:abc", expected: reference{registry: "localhost", repository: "app", digest: "sha256:abc"}},
+		{image: "quay.io/org/app:v2@sha256:def", expected: reference{registry: "quay.io", repository: "org/app", tag: "v2", digest: "sha256:def"}},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.image, func(t *testing.T) {
+			if r := parseReference(tc.image); r != tc.expected {
+				t.Errorf("expected reference %+v, got %+v", tc.expected, r)
+			}
+		})
+	}
+}
+
+func TestMutateImageRules(t *testing.T) {
+	rules := []config.ImageRule{
+		{Name: "vendor-ping", Registry: "registry.example.com", Repository: "vendor/*", Default: "true"},
+		{Repository: "library/busybox", Tag: "1.*", Default: SkipValue},
+		{Digest: "sha256:abc", Default: "true"},
+	}
+	container := func(image string) corev1.Container {
+		return corev1.Container{Name: "foo", Image: image, SecurityContext: &corev1.SecurityContext{}}
+	}
+
+	tt := []struct {
+		name          string
+		input         interface{}
+		subResource   string
+		expectedPatch []patch
+	}{
+		{
+			name:  "registry and repository",
+			input: pod("default", []corev1.Container{}, []corev1.Container{container("registry.example.com/vendor/ping:1.0")}),
+			expectedPatch: []patch{
+				{Op: "add", Path: "/metadata/annotations", Value: struct{}{}},
+				{Op: "add", Path: "/metadata/annotations/default-allow-privilege-escalation.marshallford.me~1image-rules", Value: "foo=vendor-ping"},
+				{Op: "add", Path: "/spec/containers/0/securityContext/allowPrivilegeEscalation", Value: true},
+			},
+		},
+		{
+			name: "existing annotations",
+			input: func() corev1.Pod {
+				p := pod("default", []corev1.Container{}, []corev1.Container{container("app@sha256:abc")})
+				p.Annotations = map[string]string{"team": "platform"}
+				return p
+			}(),
+			expectedPatch: []patch{
+				{Op: "add", Path: "/metadata/annotations/default-allow-privilege-escalation.marshallford.me~1image-rules", Value: "foo=images[2]"},
+				{Op: "add", Path: "/spec/containers/0/securityContext/allowPrivilegeEscalation", Value: true},
+			},
+		},
+		{
+			name:  "skip",
+			input: pod("default", []corev1.Container{}, []corev1.Container{container("busybox:1.32")}),
+		},
+		{
+			name: "already set",
+			input: func() corev1.Pod {
+				c := container("registry.example.com/vendor/ping:1.0")
+				c.SecurityContext.AllowPrivilegeEscalation = &[]bool{false}[0]
+				return pod("default", []corev1.Container{}, []corev1.Container{c})
+			}(),
+		},
+		{
+			name: "ephemeral containers subresource",
+			input: func() corev1.Pod {
+				p := pod("default", []corev1.Container{}, []corev1.Container{})
+				p.Spec.EphemeralContainers = []corev1.EphemeralContainer{{EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+					Name:            "foo",
+					Image:           "registry.example.com/vendor/ping",
+					SecurityContext: &corev1.SecurityContext{},
+				}}}
+				return p
+			}(),
+			subResource: "ephemeralcontainers",
+			expectedPatch: []patch{
+				{Op: "add", Path: "/spec/ephemeralContainers/0/securityContext/allowPrivilegeEscalation", Value: true},
+			},
+		},
+		{
+			name:  "tag mismatch",
+			input: pod("default", []corev1.Container{}, []corev1.Container{container("busybox")}),
+			expectedPatch: []patch{
+				{Op: "add", Path: "/spec/containers/0/securityContext/allowPrivilegeEscalation", Value: false},
+			},
+		},
+		{
+			name: "override annotation wins",
+			input: func() corev1.Pod {
+				p := pod("default", []corev1.Container{}, []corev1.Container{container("registry.example.com/vendor/ping")})
+				p.Annotations = map[string]string{DefaultAnnotation: "false"}
+				return p
+			}(),
+			expectedPatch: []patch{
+				{Op: "add", Path: "/spec/containers/0/securityContext/allowPrivilegeEscalation", Value: false},
+			},
+		},
+		{
+			name: "deployment",
+			input: appsv1.Deployment{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+				ObjectMeta: metav1.ObjectMeta{Name: "some-workload", Namespace: "default"},
+				Spec:       appsv1.DeploymentSpec{Template: podTemplateSpec([]corev1.Container{container("registry.example.com/vendor/ping")})},
+			},
+			expectedPatch: []patch{
+				{Op: "add", Path: "/spec/template/metadata/annotations", Value: struct{}{}},
+				{Op: "add", Path: "/spec/template/metadata/annotations/default-allow-privilege-escalation.marshallford.me~1image-rules", Value: "foo=vendor-ping"},
+				{Op: "add", Path: "/spec/template/spec/containers/0/securityContext/allowPrivilegeEscalation", Value: true},
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			objectBytes, err := json.Marshal(tc.input)
+			if err != nil {
+				t.Fatal("failed to json encode object")
+			}
+
+			request := *admissionReviewCreatePod.Request
+			request.Object.Raw = objectBytes
+			if tc.subResource != "" {
+				request.Operation = admissionv1.Update
+				request.SubResource = tc.subResource
+			}
+			admissionReview := admissionv1.AdmissionReview{}
+			admissionReview.TypeMeta = admissionReviewCreatePod.TypeMeta
+			admissionReview.Request = &request
+			res, _ := mutate(&admissionReview, options{overridesEnabled: true, imageRules: rules}, log)
+
+			if tc.expectedPatch == nil {
+				if res.Patch != nil {
+					t.Errorf("expected no patch, got %s", res.Patch)
+				}
+				return
+			}
+			expectedBytes, err := json.Marshal(tc.expectedPatch)
+			if err != nil {
+				t.Fatal("failed to json encode patch")
+			}
+			if !bytes.Equal(expectedBytes, res.Patch) {
+				t.Errorf("expected patch %s, got %s", expectedBytes, res.Patch)
+			}
+		})
+	}
+}
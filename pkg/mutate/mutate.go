@@ -1,24 +1,21 @@
 package mutate
 
 import (
+	"defaultallowpe/pkg/admission"
+	"defaultallowpe/pkg/config"
+	"defaultallowpe/pkg/metrics"
+	"defaultallowpe/pkg/policy"
 	"encoding/json"
 	"fmt"
+	"strings"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/spf13/viper"
+	"go.uber.org/zap"
 
 	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/runtime/serializer"
-	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
-)
-
-var (
-	scheme       = runtime.NewScheme()
-	codecs       = serializer.NewCodecFactory(scheme)
-	deserializer = codecs.UniversalDeserializer()
 )
 
 type patch struct {
@@ -27,161 +24,480 @@ type patch struct {
 	Value interface{} `json:"value,omitempty"`
 }
 
-type appError struct {
-	Error string `json:"error"`
+// Conflict strategies for containers that are privileged or add CAP_SYS_ADMIN
+const (
+	ConflictSkip  = "skip"
+	ConflictAllow = "allow"
+	ConflictDeny  = "deny"
+)
+
+// defaulted is a container that had allowPrivilegeEscalation defaulted
+type defaulted struct {
+	container string
+	value     bool
+	source    string
 }
 
-// Routes manages Fiber routes for mutate pkg
-func Routes(r fiber.Router, config *viper.Viper) {
-	r.Post("/mutate", HandlerFunc(config))
+// result summarizes a mutation for metrics and logging
+type result struct {
+	outcome     string
+	name        string
+	rule        string
+	profile     string
+	message     string
+	defaulted   []defaulted
+	fields      []string
+	predictions []string
+	imageRules  []string
+}
+
+// logFields are the structured fields of the log entry for a reviewed admission
+func (r result) logFields(request *admissionv1.AdmissionRequest) []interface{} {
+	containers := make([]string, 0, len(r.defaulted))
+	for _, d := range r.defaulted {
+		containers = append(containers, fmt.Sprintf("%s=%t (%s)", d.container, d.value, d.source))
+	}
+	fields := []interface{}{
+		"uid", string(request.UID),
+		"namespace", request.Namespace,
+		"name", r.name,
+		"kind", request.Kind.Kind,
+		"subResource", request.SubResource,
+		"operation", string(request.Operation),
+		"user", request.UserInfo.Username,
+		"outcome", r.outcome,
+		"rule", r.rule,
+		"containers", containers,
+	}
+	if len(r.imageRules) > 0 {
+		fields = append(fields, "imageRules", r.imageRules)
+	}
+	if r.profile != "" {
+		fields = append(fields, "profile", r.profile)
+	}
+	if len(r.fields) > 0 {
+		fields = append(fields, "fields", r.fields)
+	}
+	if len(r.predictions) > 0 {
+		fields = append(fields, "predictions", r.predictions)
+	}
+	if r.message != "" {
+		fields = append(fields, "message", r.message)
+	}
+	return fields
 }
 
-func init() {
-	utilruntime.Must(corev1.AddToScheme(scheme))
-	utilruntime.Must(admissionv1.AddToScheme(scheme))
+// Modes controlling whether computed patches are applied
+const (
+	ModeEnforce = "enforce"
+	ModeWarn    = "warn"
+	ModeAudit   = "audit"
+)
+
+// options controls which objects are mutated and how containers are patched
+type options struct {
+	mode                            string
+	defaultAllowPrivilegeEscalation bool
+	conflictStrategy                string
+	includeNamespaces               []string
+	excludeNamespaces               []string
+	exemptUsers                     []string
+	exemptGroups                    []string
+	exemptServiceAccounts           []string
+	serviceAccounts                 []string
+	serviceAccountDefault           string
+	overridesEnabled                bool
+	overrideNamespaces              []string
+	imageRules                      []config.ImageRule
+	defaulters                      config.Defaulters
+	restrictedProfile               bool
+	listers                         Listers
+}
+
+// Routes manages Fiber routes for mutate pkg
+func Routes(r fiber.Router, config *config.Snapshot, log *zap.SugaredLogger, listers Listers) {
+	r.Post("/mutate", metrics.Instrument("mutate", HandlerFunc(config, log, listers)))
 }
 
 // HandlerFunc returns a func that is a HTTP handler for mutate requests
-func HandlerFunc(config *viper.Viper) fiber.Handler {
+func HandlerFunc(config *config.Snapshot, log *zap.SugaredLogger, listers Listers) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		// validate Content-Type
-		if !c.Is("json") {
-			return c.Status(fiber.StatusUnsupportedMediaType).JSON(&appError{
-				Error: "invalid content-type, expected application/json",
-			})
+		review, appErr := admission.ReadReview(c)
+		if appErr != nil {
+			metrics.Admissions.WithLabelValues("mutate", "", "", metrics.OutcomeError).Inc()
+			log.Warnw("invalid admission review",
+				"err", appErr.Message,
+			)
+			return c.Status(appErr.Status).JSON(appErr)
 		}
 
-		// get AdmissionReview
-		reviewGVK := admissionv1.SchemeGroupVersion.WithKind("AdmissionReview")
-		obj, gvk, err := deserializer.Decode(c.Body(), &reviewGVK, &admissionv1.AdmissionReview{})
-		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(&appError{
-				Error: "could not decode AdmissionReview",
-			})
+		// mutate using a consistent config snapshot
+		cfg := config.Load()
+		admissionResponse, res := mutate(review, options{
+			mode:                            cfg.App.Mode,
+			defaultAllowPrivilegeEscalation: cfg.App.Default,
+			conflictStrategy:                cfg.App.Conflict,
+			includeNamespaces:               cfg.Namespaces.Include,
+			excludeNamespaces:               cfg.Namespaces.Exclude,
+			exemptUsers:                     cfg.Requesters.Users,
+			exemptGroups:                    cfg.Requesters.Groups,
+			exemptServiceAccounts:           cfg.Requesters.ServiceAccounts,
+			serviceAccounts:                 cfg.Accounts.Names,
+			serviceAccountDefault:           cfg.Accounts.Default,
+			overridesEnabled:                cfg.Overrides.Enabled,
+			overrideNamespaces:              cfg.Overrides.Namespaces,
+			imageRules:                      cfg.Images,
+			defaulters:                      cfg.Defaulters,
+			restrictedProfile:               cfg.Profiles.Restricted.Enabled,
+			listers:                         listers,
+		}, log)
+		log.Infow("admission reviewed", res.logFields(review.Request)...)
+		if admissionResponse.Patch != nil {
+			log.Debugw("admission patch",
+				"uid", string(review.Request.UID),
+				"patch", string(admissionResponse.Patch),
+			)
 		}
-
-		review, ok := obj.(*admissionv1.AdmissionReview)
-		if !ok {
-			return c.Status(fiber.StatusBadRequest).JSON(&appError{
-				Error: fmt.Sprintf("unexpected GroupVersionKind: %s", gvk),
-			})
+		metrics.Admissions.WithLabelValues("mutate", review.Request.Namespace, string(review.Request.Operation), res.outcome).Inc()
+		for _, d := range res.defaulted {
+			metrics.ContainersDefaulted.WithLabelValues(review.Request.Namespace, fmt.Sprintf("%t", d.value)).Inc()
 		}
 
-		// check if request is empty
-		if review.Request == nil {
-			return c.Status(fiber.StatusBadRequest).JSON(&appError{
-				Error: "unexpected nil AdmissionRequest",
-			})
-		}
+		// return new AdmissionReview
+		return admission.WriteReview(c, review, admissionResponse)
+	}
+}
 
-		// mutate
-		admissionResponse := mutate(review, config.GetBool("app.default"))
+// serviceAccountPrefix is the username prefix of requests made by service accounts
+const serviceAccountPrefix = "system:serviceaccount:"
 
-		// return new AdmissionReview
-		review.Response = admissionResponse
-		review.Response.UID = review.Request.UID
-		return c.Status(fiber.StatusOK).JSON(review)
+// requestingServiceAccount converts a service account username to namespace/name
+func requestingServiceAccount(username string) (string, bool) {
+	if !strings.HasPrefix(username, serviceAccountPrefix) {
+		return "", false
 	}
+	parts := strings.SplitN(strings.TrimPrefix(username, serviceAccountPrefix), ":", 2)
+	if len(parts) != 2 {
+		return "", false
+	}
+	return parts[0] + "/" + parts[1], true
 }
 
-func mutationRequired(metadata *metav1.ObjectMeta) bool {
-	ignoredNamespaces := []string{
-		metav1.NamespaceSystem,
-		metav1.NamespacePublic,
+// mutationRequired decides if the namespace and requesting user are in scope and describes the rule that made the decision
+func mutationRequired(metadata *metav1.ObjectMeta, user authenticationv1.UserInfo, opts options) (bool, string) {
+	if pattern, ok := admission.MatchGlob(opts.excludeNamespaces, metadata.Namespace); ok {
+		return false, fmt.Sprintf("namespaces.exclude %q", pattern)
+	}
+	if pattern, ok := admission.MatchGlob(opts.exemptUsers, user.Username); ok {
+		return false, fmt.Sprintf("exempt.users %q", pattern)
 	}
-	for _, namespace := range ignoredNamespaces {
-		if metadata.Namespace == namespace {
-			return false
+	for _, group := range user.Groups {
+		if pattern, ok := admission.MatchGlob(opts.exemptGroups, group); ok {
+			return false, fmt.Sprintf("exempt.groups %q", pattern)
 		}
 	}
-	return true
+	if account, ok := requestingServiceAccount(user.Username); ok {
+		if pattern, ok := admission.MatchGlob(opts.exemptServiceAccounts, account); ok {
+			return false, fmt.Sprintf("exempt.serviceAccounts %q", pattern)
+		}
+	}
+	if len(opts.includeNamespaces) == 0 {
+		return true, "default"
+	}
+	if pattern, ok := admission.MatchGlob(opts.includeNamespaces, metadata.Namespace); ok {
+		return true, fmt.Sprintf("namespaces.include %q", pattern)
+	}
+	return false, "namespaces.include"
 }
 
-func patchContainer(basepath string, sc *corev1.SecurityContext, defaultAllowPrivilegeEscalation bool) []patch {
-	var patches []patch
-	if sc == nil {
-		patches = append(patches, patch{
-			Op:    "add",
-			Path:  basepath,
-			Value: corev1.SecurityContext{},
-		})
+// serviceAccountDefault looks up the default for pods running as a matching service account
+func serviceAccountDefault(pt *admission.PodTemplate, opts options) (decision, bool) {
+	// the ephemeral containers subresource doesn't carry the pod spec
+	if pt.Path == "" {
+		return decision{}, false
 	}
+	account := pt.Spec.ServiceAccountName
+	if account == "" {
+		account = "default"
+	}
+	pattern, ok := admission.MatchGlob(opts.serviceAccounts, pt.Meta.Namespace+"/"+account)
+	if !ok {
+		return decision{}, false
+	}
+	d, err := parseDecision(opts.serviceAccountDefault, fmt.Sprintf("serviceAccounts.names %q", pattern))
+	return d, err == nil
+}
 
-	if sc == nil || sc.AllowPrivilegeEscalation == nil {
-		patches = append(patches, patch{
-			Op:    "add",
-			Path:  fmt.Sprintf("%v/allowPrivilegeEscalation", basepath),
-			Value: defaultAllowPrivilegeEscalation,
-		})
+// conflict describes why allowPrivilegeEscalation cannot be false for a security context
+func conflict(sc *corev1.SecurityContext) string {
+	if sc == nil {
+		return ""
 	}
-	return patches
+	if sc.Privileged != nil && *sc.Privileged {
+		return "is privileged"
+	}
+	if sc.Capabilities != nil {
+		for _, capability := range sc.Capabilities.Add {
+			if capability == "SYS_ADMIN" || capability == "CAP_SYS_ADMIN" {
+				return "adds CAP_SYS_ADMIN"
+			}
+		}
+	}
+	return ""
 }
 
-func mutate(ar *admissionv1.AdmissionReview, defaultAllowPrivilegeEscalation bool) *admissionv1.AdmissionResponse {
-	obj, _, err := deserializer.Decode(ar.Request.Object.Raw, nil, nil)
+func mutate(ar *admissionv1.AdmissionReview, opts options, log *zap.SugaredLogger) (*admissionv1.AdmissionResponse, result) {
+	res := result{outcome: metrics.OutcomeSkipped}
+	obj, err := admission.DecodeObject(ar.Request.Object.Raw)
 	if err != nil {
+		res.outcome = metrics.OutcomeError
+		res.message = err.Error()
 		return &admissionv1.AdmissionResponse{
 			Result: &metav1.Status{
 				Message: err.Error(),
 				Status:  metav1.StatusFailure,
 			},
-		}
+		}, res
 	}
 
-	pod, ok := obj.(*corev1.Pod)
+	pt, ok := admission.PodTemplateFor(obj)
 	if !ok {
+		res.outcome = metrics.OutcomeError
+		res.message = fmt.Sprintf("unexpected type %T", obj)
 		return &admissionv1.AdmissionResponse{
 			Result: &metav1.Status{
-				Message: fmt.Sprintf("unexpected type %T", obj),
+				Message: res.message,
 				Status:  metav1.StatusFailure,
 			},
-		}
+		}, res
+	}
+
+	// the object's namespace may not be set yet when created
+	if pt.Meta.Namespace == "" {
+		pt.Meta.Namespace = ar.Request.Namespace
+	}
+	res.name = pt.Meta.Name
+	if res.name == "" {
+		res.name = pt.Meta.GenerateName
+	}
+
+	// patching the containers of an existing Pod or the template of an existing Job would be rejected by the API server
+	if pt.Immutable && ar.Request.Operation == admissionv1.Update && ar.Request.SubResource != admission.EphemeralContainersSubResource {
+		res.rule = "immutable pod template"
+		return &admissionv1.AdmissionResponse{
+			Allowed: true,
+		}, res
+	}
+
+	// the deployment controller creates a new ReplicaSet whenever its template differs from the Deployment's, which is
+	// defaulted instead, the pods of the ReplicaSet are still defaulted
+	if pt.Managed {
+		res.rule = "managed pod template"
+		return &admissionv1.AdmissionResponse{
+			Allowed: true,
+		}, res
 	}
 
 	// check if mutation is required
-	if !mutationRequired(&pod.ObjectMeta) {
+	required, rule := mutationRequired(pt.Meta, ar.Request.UserInfo, opts)
+	res.rule = rule
+	if !required {
 		return &admissionv1.AdmissionResponse{
 			Allowed: true,
-		}
+		}, res
 	}
 
-	// look for containers in pod to patch
+	// look for containers in pod spec to patch, only ephemeral containers being added can be changed through the subresource
+	ephemeralOnly := ar.Request.SubResource == admission.EphemeralContainersSubResource
+	existing := map[string]bool{}
+	if ephemeralOnly {
+		if existing, err = admission.ExistingEphemeralContainers(ar.Request.OldObject.Raw); err != nil {
+			res.outcome = metrics.OutcomeError
+			res.message = err.Error()
+			return &admissionv1.AdmissionResponse{
+				Result: &metav1.Status{
+					Message: err.Error(),
+					Status:  metav1.StatusFailure,
+				},
+			}, res
+		}
+	}
 	var patches []patch
-	for i, c := range pod.Spec.InitContainers {
-		path := fmt.Sprintf("/spec/initContainers/%v/securityContext", i)
-		patches = append(patches, patchContainer(path, c.SecurityContext, defaultAllowPrivilegeEscalation)...)
+	var warnings []string
+	var auditAnnotations map[string]string
+	var nsDefault *decision
+	if d, ok, err := namespaceDefault(opts.listers.Namespaces, pt.Meta.Namespace); err != nil {
+		log.Warnw("unable to look up namespace default",
+			"namespace", pt.Meta.Namespace,
+			"err", err,
+		)
+	} else if ok {
+		nsDefault = &d
+	}
+	var restricted *profile
+	if opts.restrictedProfile {
+		if p, err := namespaceProfile(opts.listers.Namespaces, pt.Meta.Namespace); err != nil {
+			log.Warnw("unable to look up namespace profile",
+				"namespace", pt.Meta.Namespace,
+				"err", err,
+			)
+		} else if p != nil {
+			restricted = p
+			res.profile = p.String()
+		}
+	}
+	defaulters := opts.defaulters
+	if restricted != nil {
+		defaulters = restricted.defaulters(defaulters)
+	}
+	accountDefault := decision{value: opts.defaultAllowPrivilegeEscalation, source: defaultSource}
+	if d, ok := serviceAccountDefault(pt, opts); ok {
+		accountDefault = d
+	}
+	dryRun := opts.mode == ModeWarn || opts.mode == ModeAudit
+	var predictions []string
+	matched := map[policy.Reference]bool{}
+	defer recordMatches(opts.listers.Policies, matched)
+	for _, c := range pt.Containers(ephemeralOnly) {
+		if existing[c.Name] {
+			continue
+		}
+		fallback := accountDefault
+		rule, ruleMatched := "", false
+		if d, id, ok := imageRule(opts.imageRules, c); ok {
+			fallback, rule, ruleMatched = d, id, true
+		}
+		base, refs := policyDefault(opts.listers.Policies, pt, c, nsDefault, fallback)
+		for _, ref := range refs {
+			matched[ref] = true
+		}
+		d, warning := resolve(pt, c, base, opts)
+		if warning != "" {
+			warnings = append(warnings, warning)
+		}
+		if restricted != nil {
+			d = restricted.decide(d)
+		}
+		if d.source != defaultSource {
+			if auditAnnotations == nil {
+				auditAnnotations = map[string]string{}
+			}
+			auditAnnotations["override."+c.Name] = fmt.Sprintf("%s (%s)", d, d.source)
+		}
+
+		// collect every field defaulted for the container into a single set of patches
+		var fields []field
+		if !d.skip {
+			f, warning, err := allowPrivilegeEscalation(c, d.value, opts)
+			if err != nil && !dryRun {
+				res.outcome = metrics.OutcomeDenied
+				res.message = err.Error()
+				return &admissionv1.AdmissionResponse{
+					Allowed: false,
+					Result: &metav1.Status{
+						Message: err.Error(),
+						Status:  metav1.StatusFailure,
+						Reason:  metav1.StatusReasonForbidden,
+						Code:    fiber.StatusForbidden,
+					},
+				}, res
+			}
+			if err != nil {
+				predictions = append(predictions, fmt.Sprintf("would deny: %s", err))
+			}
+			if warning != "" {
+				warnings = append(warnings, warning)
+			}
+			if f != nil {
+				fields = append(fields, *f)
+				res.defaulted = append(res.defaulted, defaulted{container: c.Name, value: f.value.(bool), source: d.source})
+				// only record an image rule that decided the defaulted value
+				if ruleMatched && d.source == fallback.source && f.value.(bool) == d.value {
+					res.imageRules = append(res.imageRules, fmt.Sprintf("%s=%s", c.Name, rule))
+				}
+			}
+		}
+		others, otherWarnings := defaulterFields(c, pt.Spec.SecurityContext, defaulters)
+		warnings = append(warnings, otherWarnings...)
+		for _, f := range others {
+			res.fields = append(res.fields, fmt.Sprintf("%s.%s=%s", c.Name, f.name, f.display))
+		}
+		fields = append(fields, others...)
+		for _, f := range fields {
+			predictions = append(predictions, fmt.Sprintf("%s %q would default %s to %s", c.Kind, c.Name, f.name, f.display))
+		}
+
+		path := fmt.Sprintf("%v/%v/%v/securityContext", pt.Path, c.Field, c.Index)
+		patches = append(patches, patchContainer(path, c, fields)...)
 	}
 
-	for i, c := range pod.Spec.Containers {
-		path := fmt.Sprintf("/spec/containers/%v/securityContext", i)
-		patches = append(patches, patchContainer(path, c.SecurityContext, defaultAllowPrivilegeEscalation)...)
+	// report instead of patching when not enforcing
+	if dryRun {
+		res.defaulted = nil
+		res.fields = nil
+		if len(predictions) > 0 {
+			res.predictions = predictions
+		}
+	}
+	switch opts.mode {
+	case ModeWarn:
+		if len(predictions) > 0 {
+			res.outcome = metrics.OutcomeWarned
+		}
+		return &admissionv1.AdmissionResponse{
+			Allowed:          true,
+			Warnings:         append(warnings, predictions...),
+			AuditAnnotations: auditAnnotations,
+		}, res
+	case ModeAudit:
+		if len(predictions) > 0 {
+			res.outcome = metrics.OutcomeAudited
+		}
+		return &admissionv1.AdmissionResponse{
+			Allowed:          true,
+			AuditAnnotations: auditAnnotations,
+		}, res
+	}
+
+	// record the matched image rules on the pod, the ephemeral containers subresource cannot change metadata
+	if !ephemeralOnly {
+		patches = append(annotateImageRules(pt, res.imageRules), patches...)
 	}
 
 	// allow request if there aren't any patches
 	if len(patches) == 0 {
 		return &admissionv1.AdmissionResponse{
-			Allowed: true,
-		}
+			Allowed:          true,
+			Warnings:         warnings,
+			AuditAnnotations: auditAnnotations,
+		}, res
 	}
 
 	// encodes patches as json
 	patchBytes, err := json.Marshal(patches)
 	if err != nil {
+		res.outcome = metrics.OutcomeError
+		res.message = err.Error()
+		res.defaulted = nil
+		res.fields = nil
 		return &admissionv1.AdmissionResponse{
 			Result: &metav1.Status{
 				Message: err.Error(),
 				Status:  metav1.StatusFailure,
 			},
-		}
+		}, res
 	}
 
 	// respond with patches
+	res.outcome = metrics.OutcomePatched
 	return &admissionv1.AdmissionResponse{
-		Allowed: true,
-		Patch:   patchBytes,
+		Allowed:          true,
+		Warnings:         warnings,
+		AuditAnnotations: auditAnnotations,
+		Patch:            patchBytes,
 		PatchType: func() *admissionv1.PatchType {
 			pt := admissionv1.PatchTypeJSONPatch
 			return &pt
 		}(),
-	}
+	}, res
 }
@@ -3,6 +3,7 @@ package mutate
 import (
 	"bytes"
 	"defaultallowpe/pkg/config"
+	"defaultallowpe/pkg/metrics"
 	"encoding/json"
 	"io"
 	"io/ioutil"
@@ -12,12 +13,22 @@ import (
 	"testing"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
 	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 var (
+	log               = zap.NewNop().Sugar()
+	excludeNamespaces = []string{metav1.NamespaceSystem, metav1.NamespacePublic}
+
 	admissionReviewCreatePod = admissionv1.AdmissionReview{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: "admission.k8s.io/v1",
@@ -53,6 +64,16 @@ var (
 		SecurityContext: &corev1.SecurityContext{},
 	}
 	containerSecurityContextWithOtherField = corev1.Container{
+		Name:  "foo",
+		Image: "image:tag",
+		SecurityContext: &corev1.SecurityContext{
+			RunAsNonRoot: func() *bool {
+				b := true
+				return &b
+			}(),
+		},
+	}
+	containerPrivileged = corev1.Container{
 		Name:  "foo",
 		Image: "image:tag",
 		SecurityContext: &corev1.SecurityContext{
@@ -62,6 +83,15 @@ var (
 			}(),
 		},
 	}
+	containerSysAdmin = corev1.Container{
+		Name:  "foo",
+		Image: "image:tag",
+		SecurityContext: &corev1.SecurityContext{
+			Capabilities: &corev1.Capabilities{
+				Add: []corev1.Capability{"SYS_ADMIN"},
+			},
+		},
+	}
 	containerSecurityContextWithField = corev1.Container{
 		Name:  "foo",
 		Image: "image:tag",
@@ -91,6 +121,14 @@ func pod(ns string, initContainers []corev1.Container, containers []corev1.Conta
 	}
 }
 
+func podTemplateSpec(containers []corev1.Container) corev1.PodTemplateSpec {
+	return corev1.PodTemplateSpec{
+		Spec: corev1.PodSpec{
+			Containers: containers,
+		},
+	}
+}
+
 func TestMutateBadAdmissionRequestObject(t *testing.T) {
 	secretBytes, err := json.Marshal(secret)
 
@@ -121,7 +159,7 @@ func TestMutateBadAdmissionRequestObject(t *testing.T) {
 			admissionReview.Request = admissionReviewCreatePod.Request
 			admissionReview.Request.Kind = admissionReviewCreatePod.Request.Kind
 			admissionReview.Request.Object.Raw = tc.input
-			res := mutate(&admissionReview, false)
+			res, _ := mutate(&admissionReview, options{excludeNamespaces: excludeNamespaces}, log)
 			if res.Result.Message != tc.expected {
 				t.Errorf("expected message %s, got %s", tc.expected, res.Result.Message)
 			}
@@ -165,7 +203,7 @@ func TestMutateNoPatches(t *testing.T) {
 			admissionReview.Request = admissionReviewCreatePod.Request
 			admissionReview.Request.Kind = admissionReviewCreatePod.Request.Kind
 			admissionReview.Request.Object.Raw = podBytes
-			res := mutate(&admissionReview, false)
+			res, _ := mutate(&admissionReview, options{excludeNamespaces: excludeNamespaces}, log)
 
 			if res.Patch != nil {
 				t.Errorf("expected no patch, got %s", res.Patch)
@@ -275,7 +313,7 @@ func TestMutatePatches(t *testing.T) {
 			admissionReview.Request = admissionReviewCreatePod.Request
 			admissionReview.Request.Kind = admissionReviewCreatePod.Request.Kind
 			admissionReview.Request.Object.Raw = podBytes
-			res := mutate(&admissionReview, false)
+			res, _ := mutate(&admissionReview, options{excludeNamespaces: excludeNamespaces}, log)
 
 			expectedBytes, err := json.Marshal(tc.expected)
 			if err != nil {
@@ -288,6 +326,487 @@ func TestMutatePatches(t *testing.T) {
 	}
 }
 
+func TestMutationRequired(t *testing.T) {
+	tt := []struct {
+		name      string
+		namespace string
+		include   []string
+		exclude   []string
+		user      authenticationv1.UserInfo
+		expected  bool
+		rule      string
+	}{
+		{
+			name:      "no rules",
+			namespace: "default",
+			expected:  true,
+			rule:      "default",
+		},
+		{
+			name:      "exclude exact",
+			namespace: "kube-system",
+			exclude:   excludeNamespaces,
+			expected:  false,
+			rule:      `namespaces.exclude "kube-system"`,
+		},
+		{
+			name:      "exclude glob",
+			namespace: "team-a-dev",
+			exclude:   []string{"*-dev"},
+			expected:  false,
+			rule:      `namespaces.exclude "*-dev"`,
+		},
+		{
+			name:      "include glob",
+			namespace: "team-a",
+			include:   []string{"team-*"},
+			expected:  true,
+			rule:      `namespaces.include "team-*"`,
+		},
+		{
+			name:      "not included",
+			namespace: "default",
+			include:   []string{"team-*"},
+			expected:  false,
+			rule:      "namespaces.include",
+		},
+		{
+			name:      "exclude wins over include",
+			namespace: "team-a",
+			include:   []string{"team-*"},
+			exclude:   []string{"team-a"},
+			expected:  false,
+			rule:      `namespaces.exclude "team-a"`,
+		},
+		{
+			name:      "exempt user",
+			namespace: "default",
+			user:      authenticationv1.UserInfo{Username: "jane"},
+			expected:  false,
+			rule:      `exempt.users "jane"`,
+		},
+		{
+			name:      "exempt group",
+			namespace: "default",
+			user:      authenticationv1.UserInfo{Username: "admin", Groups: []string{"system:authenticated", "system:masters"}},
+			expected:  false,
+			rule:      `exempt.groups "system:masters"`,
+		},
+		{
+			name:      "exempt service account",
+			namespace: "default",
+			user:      authenticationv1.UserInfo{Username: "system:serviceaccount:node-agent:operator"},
+			expected:  false,
+			rule:      `exempt.serviceAccounts "node-agent/*"`,
+		},
+		{
+			name:      "other service account",
+			namespace: "default",
+			user:      authenticationv1.UserInfo{Username: "system:serviceaccount:kube-system:replicaset-controller"},
+			expected:  true,
+			rule:      "default",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			required, rule := mutationRequired(&metav1.ObjectMeta{Namespace: tc.namespace}, tc.user, options{
+				includeNamespaces:     tc.include,
+				excludeNamespaces:     tc.exclude,
+				exemptUsers:           []string{"jane"},
+				exemptGroups:          []string{"system:masters"},
+				exemptServiceAccounts: []string{"node-agent/*"},
+			})
+			if required != tc.expected {
+				t.Errorf("expected required %t, got %t", tc.expected, required)
+			}
+			if rule != tc.rule {
+				t.Errorf("expected rule %s, got %s", tc.rule, rule)
+			}
+		})
+	}
+}
+
+func TestMutateConflicts(t *testing.T) {
+	tt := []struct {
+		name             string
+		input            corev1.Pod
+		strategy         string
+		expectedPatch    []patch
+		expectedWarnings []string
+		expectedDenied   string
+	}{
+		{
+			name:             "privileged skip",
+			input:            pod("default", []corev1.Container{}, []corev1.Container{containerPrivileged}),
+			strategy:         ConflictSkip,
+			expectedWarnings: []string{`container "foo" is privileged, skipped defaulting allowPrivilegeEscalation`},
+		},
+		{
+			name:     "sys admin allow",
+			input:    pod("default", []corev1.Container{containerSysAdmin}, []corev1.Container{}),
+			strategy: ConflictAllow,
+			expectedPatch: []patch{
+				{
+					Op:    "add",
+					Path:  "/spec/initContainers/0/securityContext/allowPrivilegeEscalation",
+					Value: true,
+				},
+			},
+			expectedWarnings: []string{`init container "foo" adds CAP_SYS_ADMIN, defaulted allowPrivilegeEscalation to true`},
+		},
+		{
+			name:           "privileged deny",
+			input:          pod("default", []corev1.Container{}, []corev1.Container{containerPrivileged}),
+			strategy:       ConflictDeny,
+			expectedDenied: `container "foo" is privileged and must explicitly set allowPrivilegeEscalation`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			podBytes, err := json.Marshal(tc.input)
+			if err != nil {
+				t.Fatal("failed to json encode Pod")
+			}
+
+			admissionReview := admissionv1.AdmissionReview{}
+			admissionReview.TypeMeta = admissionReviewCreatePod.TypeMeta
+			admissionReview.Request = admissionReviewCreatePod.Request
+			admissionReview.Request.Object.Raw = podBytes
+			res, _ := mutate(&admissionReview, options{conflictStrategy: tc.strategy}, log)
+
+			if tc.expectedDenied != "" {
+				if res.Allowed {
+					t.Fatal("expected allowed false, got allowed true")
+				}
+				if res.Result.Message != tc.expectedDenied {
+					t.Errorf("expected message %s, got %s", tc.expectedDenied, res.Result.Message)
+				}
+				return
+			}
+
+			if tc.expectedPatch == nil {
+				if res.Patch != nil {
+					t.Errorf("expected no patch, got %s", res.Patch)
+				}
+			} else {
+				expectedBytes, err := json.Marshal(tc.expectedPatch)
+				if err != nil {
+					t.Fatal("failed to json encode patch")
+				}
+				if !bytes.Equal(expectedBytes, res.Patch) {
+					t.Errorf("expected patch %s, got %s", expectedBytes, res.Patch)
+				}
+			}
+			if strings.Join(res.Warnings, "\n") != strings.Join(tc.expectedWarnings, "\n") {
+				t.Errorf("expected warnings %v, got %v", tc.expectedWarnings, res.Warnings)
+			}
+		})
+	}
+}
+
+func TestMutateModes(t *testing.T) {
+	tt := []struct {
+		name             string
+		mode             string
+		conflict         string
+		input            corev1.Pod
+		expectedPatch    bool
+		expectedAllowed  bool
+		expectedWarnings []string
+		expectedOutcome  string
+	}{
+		{
+			name:            "enforce",
+			mode:            ModeEnforce,
+			input:           pod("default", []corev1.Container{}, []corev1.Container{containerNoSecurityContext}),
+			expectedPatch:   true,
+			expectedAllowed: true,
+			expectedOutcome: metrics.OutcomePatched,
+		},
+		{
+			name:             "warn",
+			mode:             ModeWarn,
+			input:            pod("default", []corev1.Container{}, []corev1.Container{containerNoSecurityContext}),
+			expectedAllowed:  true,
+			expectedWarnings: []string{`container "foo" would default allowPrivilegeEscalation to false`},
+			expectedOutcome:  metrics.OutcomeWarned,
+		},
+		{
+			name:             "warn conflict deny",
+			mode:             ModeWarn,
+			conflict:         ConflictDeny,
+			input:            pod("default", []corev1.Container{}, []corev1.Container{containerPrivileged}),
+			expectedAllowed:  true,
+			expectedWarnings: []string{`would deny: container "foo" is privileged and must explicitly set allowPrivilegeEscalation`},
+			expectedOutcome:  metrics.OutcomeWarned,
+		},
+		{
+			name:            "audit",
+			mode:            ModeAudit,
+			input:           pod("default", []corev1.Container{}, []corev1.Container{containerNoSecurityContext}),
+			expectedAllowed: true,
+			expectedOutcome: metrics.OutcomeAudited,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			podBytes, err := json.Marshal(tc.input)
+			if err != nil {
+				t.Fatal("failed to json encode Pod")
+			}
+
+			admissionReview := admissionv1.AdmissionReview{}
+			admissionReview.TypeMeta = admissionReviewCreatePod.TypeMeta
+			admissionReview.Request = admissionReviewCreatePod.Request
+			admissionReview.Request.Object.Raw = podBytes
+			res, r := mutate(&admissionReview, options{mode: tc.mode, conflictStrategy: tc.conflict}, log)
+
+			if res.Allowed != tc.expectedAllowed {
+				t.Errorf("expected allowed %t, got %t", tc.expectedAllowed, res.Allowed)
+			}
+			if (res.Patch != nil) != tc.expectedPatch {
+				t.Errorf("expected patch %t, got %s", tc.expectedPatch, res.Patch)
+			}
+			if strings.Join(res.Warnings, "\n") != strings.Join(tc.expectedWarnings, "\n") {
+				t.Errorf("expected warnings %v, got %v", tc.expectedWarnings, res.Warnings)
+			}
+			if r.outcome != tc.expectedOutcome {
+				t.Errorf("expected outcome %s, got %s", tc.expectedOutcome, r.outcome)
+			}
+		})
+	}
+}
+
+func TestMutateWorkloadPatches(t *testing.T) {
+	containers := []corev1.Container{containerSecurityContextEmpty}
+	objectMeta := metav1.ObjectMeta{
+		Name:      "some-workload",
+		Namespace: "default",
+	}
+	tt := []struct {
+		name     string
+		input    interface{}
+		expected string
+	}{
+		{
+			name: "deployment",
+			input: appsv1.Deployment{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+				ObjectMeta: objectMeta,
+				Spec:       appsv1.DeploymentSpec{Template: podTemplateSpec(containers)},
+			},
+			expected: "/spec/template/spec/containers/0/securityContext/allowPrivilegeEscalation",
+		},
+		{
+			name: "statefulset",
+			input: appsv1.StatefulSet{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "StatefulSet"},
+				ObjectMeta: objectMeta,
+				Spec:       appsv1.StatefulSetSpec{Template: podTemplateSpec(containers)},
+			},
+			expected: "/spec/template/spec/containers/0/securityContext/allowPrivilegeEscalation",
+		},
+		{
+			name: "daemonset",
+			input: appsv1.DaemonSet{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "DaemonSet"},
+				ObjectMeta: objectMeta,
+				Spec:       appsv1.DaemonSetSpec{Template: podTemplateSpec(containers)},
+			},
+			expected: "/spec/template/spec/containers/0/securityContext/allowPrivilegeEscalation",
+		},
+		{
+			name: "replicaset",
+			input: appsv1.ReplicaSet{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "ReplicaSet"},
+				ObjectMeta: objectMeta,
+				Spec:       appsv1.ReplicaSetSpec{Template: podTemplateSpec(containers)},
+			},
+			expected: "/spec/template/spec/containers/0/securityContext/allowPrivilegeEscalation",
+		},
+		{
+			name: "job",
+			input: batchv1.Job{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "batch/v1", Kind: "Job"},
+				ObjectMeta: objectMeta,
+				Spec:       batchv1.JobSpec{Template: podTemplateSpec(containers)},
+			},
+			expected: "/spec/template/spec/containers/0/securityContext/allowPrivilegeEscalation",
+		},
+		{
+			name: "cronjob",
+			input: batchv1.CronJob{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "batch/v1", Kind: "CronJob"},
+				ObjectMeta: objectMeta,
+				Spec: batchv1.CronJobSpec{
+					JobTemplate: batchv1.JobTemplateSpec{
+						Spec: batchv1.JobSpec{Template: podTemplateSpec(containers)},
+					},
+				},
+			},
+			expected: "/spec/jobTemplate/spec/template/spec/containers/0/securityContext/allowPrivilegeEscalation",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			objBytes, err := json.Marshal(tc.input)
+			if err != nil {
+				t.Fatal("failed to json encode workload")
+			}
+
+			admissionReview := admissionv1.AdmissionReview{}
+			admissionReview.TypeMeta = admissionReviewCreatePod.TypeMeta
+			admissionReview.Request = admissionReviewCreatePod.Request
+			admissionReview.Request.Object.Raw = objBytes
+			res, _ := mutate(&admissionReview, options{excludeNamespaces: excludeNamespaces}, log)
+
+			expectedBytes, err := json.Marshal([]patch{
+				{
+					Op:    "add",
+					Path:  tc.expected,
+					Value: false,
+				},
+			})
+			if err != nil {
+				t.Fatal("failed to json encode patch")
+			}
+			if !bytes.Equal(expectedBytes, res.Patch) {
+				t.Errorf("expected patch %s, got %s", expectedBytes, res.Patch)
+			}
+		})
+	}
+}
+
+func TestMutateEphemeralContainers(t *testing.T) {
+	ephemeralContainer := corev1.EphemeralContainer{
+		EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+			Name:  "debugger",
+			Image: "image:tag",
+		},
+	}
+	podWithEphemeral := pod("default", []corev1.Container{}, []corev1.Container{containerNoSecurityContext})
+	podWithEphemeral.Spec.EphemeralContainers = []corev1.EphemeralContainer{ephemeralContainer}
+	ephemeralContainers := corev1.EphemeralContainers{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "EphemeralContainers",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "some-pod",
+			Namespace: "default",
+		},
+		EphemeralContainers: []corev1.EphemeralContainer{ephemeralContainer},
+	}
+
+	tt := []struct {
+		name        string
+		input       interface{}
+		subResource string
+		expected    []patch
+	}{
+		{
+			name:  "pod",
+			input: podWithEphemeral,
+		},
+		{
+			name:        "subresource pod",
+			input:       podWithEphemeral,
+			subResource: "ephemeralcontainers",
+			expected: []patch{
+				{Op: "add", Path: "/spec/ephemeralContainers/0/securityContext", Value: struct{}{}},
+				{Op: "add", Path: "/spec/ephemeralContainers/0/securityContext/allowPrivilegeEscalation", Value: false},
+			},
+		},
+		{
+			name:        "subresource ephemeral containers",
+			input:       ephemeralContainers,
+			subResource: "ephemeralcontainers",
+			expected: []patch{
+				{Op: "add", Path: "/ephemeralContainers/0/securityContext", Value: struct{}{}},
+				{Op: "add", Path: "/ephemeralContainers/0/securityContext/allowPrivilegeEscalation", Value: false},
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			objBytes, err := json.Marshal(tc.input)
+			if err != nil {
+				t.Fatal("failed to json encode object")
+			}
+
+			request := *admissionReviewCreatePod.Request
+			request.Operation = admissionv1.Update
+			request.SubResource = tc.subResource
+			request.Object.Raw = objBytes
+			admissionReview := admissionv1.AdmissionReview{}
+			admissionReview.TypeMeta = admissionReviewCreatePod.TypeMeta
+			admissionReview.Request = &request
+			res, _ := mutate(&admissionReview, options{excludeNamespaces: excludeNamespaces}, log)
+
+			if tc.expected == nil {
+				if res.Patch != nil {
+					t.Errorf("expected no patch, got %s", res.Patch)
+				}
+				return
+			}
+			expectedBytes, err := json.Marshal(tc.expected)
+			if err != nil {
+				t.Fatal("failed to json encode patch")
+			}
+			if !bytes.Equal(expectedBytes, res.Patch) {
+				t.Errorf("expected patch %s, got %s", expectedBytes, res.Patch)
+			}
+		})
+	}
+}
+
+func TestMutateEphemeralContainersExisting(t *testing.T) {
+	existing := corev1.EphemeralContainer{
+		EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "debugger", Image: "image:tag"},
+	}
+	added := corev1.EphemeralContainer{
+		EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "debugger-2", Image: "image:tag"},
+	}
+	oldPod := pod("default", []corev1.Container{}, []corev1.Container{containerNoSecurityContext})
+	oldPod.Spec.EphemeralContainers = []corev1.EphemeralContainer{existing}
+	newPod := oldPod
+	newPod.Spec.EphemeralContainers = []corev1.EphemeralContainer{existing, added}
+
+	oldBytes, err := json.Marshal(oldPod)
+	if err != nil {
+		t.Fatal("failed to json encode Pod")
+	}
+	newBytes, err := json.Marshal(newPod)
+	if err != nil {
+		t.Fatal("failed to json encode Pod")
+	}
+	request := *admissionReviewCreatePod.Request
+	request.Operation = admissionv1.Update
+	request.SubResource = "ephemeralcontainers"
+	request.Object.Raw = newBytes
+	request.OldObject.Raw = oldBytes
+	admissionReview := admissionv1.AdmissionReview{}
+	admissionReview.TypeMeta = admissionReviewCreatePod.TypeMeta
+	admissionReview.Request = &request
+	res, _ := mutate(&admissionReview, options{}, log)
+
+	expectedBytes, err := json.Marshal([]patch{
+		{Op: "add", Path: "/spec/ephemeralContainers/1/securityContext", Value: struct{}{}},
+		{Op: "add", Path: "/spec/ephemeralContainers/1/securityContext/allowPrivilegeEscalation", Value: false},
+	})
+	if err != nil {
+		t.Fatal("failed to json encode patch")
+	}
+	if !bytes.Equal(expectedBytes, res.Patch) {
+		t.Errorf("expected patch %s, got %s", expectedBytes, res.Patch)
+	}
+}
+
 func TestMutateApiFailures(t *testing.T) {
 	secretBytes, err := json.Marshal(secret)
 	if err != nil {
@@ -345,7 +864,7 @@ func TestMutateApiFailures(t *testing.T) {
 
 			config, _ := config.New()
 			app := fiber.New()
-			Routes(app.Group(""), config)
+			Routes(app.Group(""), config, log, Listers{})
 			res, _ := app.Test(req)
 
 			if res.StatusCode != tc.expectedStatusCode {
@@ -376,43 +895,297 @@ func TestMutateApiSuccess(t *testing.T) {
 		t.Fatal("failed to json encode Pod")
 	}
 
-	admissionReview := admissionv1.AdmissionReview{}
-	admissionReview.TypeMeta = admissionReviewCreatePod.TypeMeta
-	admissionReview.Request = admissionReviewCreatePod.Request
-	admissionReview.Request.Kind = admissionReviewCreatePod.Request.Kind
+	tt := []struct {
+		name       string
+		apiVersion string
+	}{
+		{
+			name:       "v1",
+			apiVersion: "admission.k8s.io/v1",
+		},
+		{
+			name:       "v1beta1",
+			apiVersion: "admission.k8s.io/v1beta1",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			admissionReview := admissionv1.AdmissionReview{}
+			admissionReview.TypeMeta = admissionReviewCreatePod.TypeMeta
+			admissionReview.APIVersion = tc.apiVersion
+			admissionReview.Request = admissionReviewCreatePod.Request
+			admissionReview.Request.Kind = admissionReviewCreatePod.Request.Kind
+			admissionReview.Request.Object.Raw = podBytes
+			arBytes, err := json.Marshal(admissionReview)
+			if err != nil {
+				t.Fatal("failed to json encode AdmissionReview")
+			}
+
+			req := httptest.NewRequest("POST", "/mutate", bytes.NewReader(arBytes))
+			req.Header.Set("Content-Type", "application/json")
+
+			config, _ := config.New()
+			app := fiber.New()
+			Routes(app.Group(""), config, log, Listers{})
+			res, _ := app.Test(req)
+
+			if res.StatusCode != http.StatusOK {
+				t.Errorf("expected status code %d, got %d", http.StatusOK, res.StatusCode)
+			}
+
+			bodyBytes, err := ioutil.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			var resBody map[string]interface{}
+			err = json.Unmarshal(bodyBytes, &resBody)
+			if err != nil {
+				t.Fatal("failed to json decode res body")
+			}
+			if resBody["apiVersion"] != tc.apiVersion {
+				t.Errorf("expected apiVersion %s, got %s", tc.apiVersion, resBody["apiVersion"])
+			}
+			response := resBody["response"].(map[string]interface{})
+			if response["uid"] != string(admissionReviewCreatePod.Request.UID) {
+				t.Errorf("expected uid %s, got %s", admissionReviewCreatePod.Request.UID, response["uid"])
+			}
+			if !response["allowed"].(bool) {
+				t.Error("expected allowed true, got allowed false")
+			}
+			expected := "JSONPatch"
+			patchType := response["patchType"].(string)
+			if patchType != expected {
+				t.Errorf("expected patchType %s, got patchType %s", expected, patchType)
+			}
+		})
+	}
+
+	patched := testutil.ToFloat64(metrics.Admissions.WithLabelValues("mutate", "", "CREATE", metrics.OutcomePatched))
+	if patched != float64(len(tt)) {
+		t.Errorf("expected %d patched admissions, got %v", len(tt), patched)
+	}
+}
+
+func TestMutateLogging(t *testing.T) {
+	pod := pod("default", []corev1.Container{}, []corev1.Container{containerNoSecurityContext})
+	podBytes, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatal("failed to json encode Pod")
+	}
+	admissionReview := admissionReviewCreatePod
+	admissionReview.Request = admissionReviewCreatePod.Request.DeepCopy()
+	admissionReview.Request.Namespace = "default"
+	admissionReview.Request.UserInfo.Username = "jane"
 	admissionReview.Request.Object.Raw = podBytes
 	arBytes, err := json.Marshal(admissionReview)
 	if err != nil {
 		t.Fatal("failed to json encode AdmissionReview")
 	}
 
-	req := httptest.NewRequest("POST", "/mutate", bytes.NewReader(arBytes))
-	req.Header.Set("Content-Type", "application/json")
+	tt := []struct {
+		name    string
+		level   zapcore.Level
+		entries []string
+	}{
+		{
+			name:    "info",
+			level:   zapcore.InfoLevel,
+			entries: []string{"admission reviewed"},
+		},
+		{
+			name:    "debug",
+			level:   zapcore.DebugLevel,
+			entries: []string{"admission reviewed", "admission patch"},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			core, logs := observer.New(tc.level)
+			req := httptest.NewRequest("POST", "/mutate", bytes.NewReader(arBytes))
+			req.Header.Set("Content-Type", "application/json")
 
-	config, _ := config.New()
-	app := fiber.New()
-	Routes(app.Group(""), config)
-	res, _ := app.Test(req)
+			config, _ := config.New()
+			app := fiber.New()
+			Routes(app.Group(""), config, zap.New(core).Sugar(), Listers{})
+			_, _ = app.Test(req)
 
-	if res.StatusCode != http.StatusOK {
-		t.Errorf("expected status code %d, got %d", http.StatusOK, res.StatusCode)
+			entries := logs.AllUntimed()
+			if len(entries) != len(tc.entries) {
+				t.Fatalf("expected %d log entries, got %d", len(tc.entries), len(entries))
+			}
+			for i, entry := range entries {
+				if entry.Message != tc.entries[i] {
+					t.Errorf("expected log entry %q, got %q", tc.entries[i], entry.Message)
+				}
+			}
+			fields := entries[0].ContextMap()
+			if fields["uid"] != string(admissionReview.Request.UID) {
+				t.Errorf("expected uid %s, got %v", admissionReview.Request.UID, fields["uid"])
+			}
+			if fields["name"] != "some-pod" {
+				t.Errorf("expected name some-pod, got %v", fields["name"])
+			}
+			if fields["user"] != "jane" {
+				t.Errorf("expected user jane, got %v", fields["user"])
+			}
+			if fields["outcome"] != metrics.OutcomePatched {
+				t.Errorf("expected outcome %s, got %v", metrics.OutcomePatched, fields["outcome"])
+			}
+			containers := fields["containers"].([]interface{})
+			if len(containers) != 1 || containers[0] != "foo=false (app.default)" {
+				t.Errorf("expected containers [foo=false (app.default)], got %v", containers)
+			}
+		})
 	}
+}
 
-	bodyBytes, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		t.Fatal(err.Error())
+func TestMutateServiceAccountDefault(t *testing.T) {
+	opts := options{
+		serviceAccounts:       []string{"monitoring/*", "default/default"},
+		serviceAccountDefault: "true",
+		imageRules:            []config.ImageRule{{Repository: "vendor/*", Default: "false"}},
 	}
-	var resBody map[string]interface{}
-	err = json.Unmarshal(bodyBytes, &resBody)
-	if err != nil {
-		t.Fatal("failed to json decode res body")
+
+	tt := []struct {
+		name           string
+		namespace      string
+		serviceAccount string
+		image          string
+		expected       bool
+	}{
+		{
+			name:           "matching service account",
+			namespace:      "monitoring",
+			serviceAccount: "node-exporter",
+			image:          "image:tag",
+			expected:       true,
+		},
+		{
+			name:      "default service account",
+			namespace: "default",
+			image:     "image:tag",
+			expected:  true,
+		},
+		{
+			name:           "other service account",
+			namespace:      "default",
+			serviceAccount: "app",
+			image:          "image:tag",
+			expected:       false,
+		},
+		{
+			name:           "image rule wins",
+			namespace:      "monitoring",
+			serviceAccount: "node-exporter",
+			image:          "vendor/exporter",
+			expected:       false,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			input := pod(tc.namespace, []corev1.Container{}, []corev1.Container{{Name: "foo", Image: tc.image, SecurityContext: &corev1.SecurityContext{}}})
+			input.Spec.ServiceAccountName = tc.serviceAccount
+			podBytes, err := json.Marshal(input)
+			if err != nil {
+				t.Fatal("failed to json encode Pod")
+			}
+
+			admissionReview := admissionv1.AdmissionReview{}
+			admissionReview.TypeMeta = admissionReviewCreatePod.TypeMeta
+			admissionReview.Request = admissionReviewCreatePod.Request
+			admissionReview.Request.Object.Raw = podBytes
+			res, _ := mutate(&admissionReview, opts, log)
+
+			var patches []patch
+			if err := json.Unmarshal(res.Patch, &patches); err != nil {
+				t.Fatalf("failed to json decode patch %s", res.Patch)
+			}
+			last := patches[len(patches)-1]
+			if last.Path != "/spec/containers/0/securityContext/allowPrivilegeEscalation" || last.Value != tc.expected {
+				t.Errorf("expected allowPrivilegeEscalation %t, got %s", tc.expected, res.Patch)
+			}
+		})
+	}
+}
+
+func TestMutateImmutableTemplates(t *testing.T) {
+	containers := []corev1.Container{containerSecurityContextEmpty}
+	objectMeta := metav1.ObjectMeta{
+		Name:      "some-workload",
+		Namespace: "default",
+	}
+	job := batchv1.Job{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "batch/v1", Kind: "Job"},
+		ObjectMeta: objectMeta,
+		Spec:       batchv1.JobSpec{Template: podTemplateSpec(containers)},
+	}
+	cronJob := batchv1.CronJob{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "batch/v1", Kind: "CronJob"},
+		ObjectMeta: objectMeta,
+		Spec: batchv1.CronJobSpec{
+			JobTemplate: batchv1.JobTemplateSpec{
+				Spec: batchv1.JobSpec{Template: podTemplateSpec(containers)},
+			},
+		},
 	}
-	if !resBody["response"].(map[string]interface{})["allowed"].(bool) {
-		t.Error("expected allowed true, got allowed false")
+	controller := true
+	replicaSet := func(owner *metav1.OwnerReference) appsv1.ReplicaSet {
+		rs := appsv1.ReplicaSet{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "ReplicaSet"},
+			ObjectMeta: *objectMeta.DeepCopy(),
+			Spec:       appsv1.ReplicaSetSpec{Template: podTemplateSpec(containers)},
+		}
+		if owner != nil {
+			rs.OwnerReferences = []metav1.OwnerReference{*owner}
+		}
+		return rs
 	}
-	expected := "JSONPatch"
-	patchType := resBody["response"].(map[string]interface{})["patchType"].(string)
-	if patchType != expected {
-		t.Errorf("expected patchType %s, got patchType %s", expected, patchType)
+
+	tt := []struct {
+		name      string
+		input     interface{}
+		operation admissionv1.Operation
+		patched   bool
+	}{
+		{name: "pod create", input: pod("default", []corev1.Container{}, containers), operation: admissionv1.Create, patched: true},
+		{name: "pod update", input: pod("default", []corev1.Container{}, containers), operation: admissionv1.Update, patched: false},
+		{name: "job create", input: job, operation: admissionv1.Create, patched: true},
+		{name: "job update", input: job, operation: admissionv1.Update, patched: false},
+		{name: "cronjob update", input: cronJob, operation: admissionv1.Update, patched: true},
+		{name: "replicaset create", input: replicaSet(nil), operation: admissionv1.Create, patched: true},
+		{name: "replicaset update", input: replicaSet(nil), operation: admissionv1.Update, patched: true},
+		{name: "deployment replicaset create", input: replicaSet(&metav1.OwnerReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "some-workload", Controller: &controller}), operation: admissionv1.Create, patched: false},
+		{name: "deployment replicaset update", input: replicaSet(&metav1.OwnerReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "some-workload", Controller: &controller}), operation: admissionv1.Update, patched: false},
+		{name: "other owner replicaset create", input: replicaSet(&metav1.OwnerReference{APIVersion: "example.com/v1", Kind: "Rollout", Name: "some-workload", Controller: &controller}), operation: admissionv1.Create, patched: true},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			objBytes, err := json.Marshal(tc.input)
+			if err != nil {
+				t.Fatal("failed to json encode workload")
+			}
+
+			request := *admissionReviewCreatePod.Request
+			request.Operation = tc.operation
+			request.Object.Raw = objBytes
+			admissionReview := admissionv1.AdmissionReview{}
+			admissionReview.TypeMeta = admissionReviewCreatePod.TypeMeta
+			admissionReview.Request = &request
+			// existing objects predate the defaulters, their fields are still nil
+			res, _ := mutate(&admissionReview, options{defaulters: config.Defaulters{
+				RunAsNonRoot: config.BoolDefaulter{Enabled: true, Value: true},
+			}}, log)
+
+			if patched := res.Patch != nil; patched != tc.patched {
+				t.Errorf("expected patched %t, got patch %s", tc.patched, res.Patch)
+			}
+			if !res.Allowed {
+				t.Error("expected allowed true, got allowed false")
+			}
+		})
 	}
 }
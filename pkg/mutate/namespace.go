@@ -0,0 +1,37 @@
+package mutate
+
+import (
+	"defaultallowpe/pkg/policy"
+	"fmt"
+
+	corelisters "k8s.io/client-go/listers/core/v1"
+)
+
+// DefaultLabel on a Namespace sets the default for pods within it, the annotation of the same name is also honoured
+const DefaultLabel = DefaultAnnotation
+
+// Listers provide cached cluster state used when resolving defaults, nil listers are not consulted
+type Listers struct {
+	Namespaces corelisters.NamespaceLister
+	Policies   *policy.Store
+}
+
+// namespaceDefault looks up the default set on a namespace through its labels or annotations
+func namespaceDefault(lister corelisters.NamespaceLister, name string) (decision, bool, error) {
+	if lister == nil {
+		return decision{}, false, nil
+	}
+	namespace, err := lister.Get(name)
+	if err != nil {
+		return decision{}, false, err
+	}
+	if value, ok := namespace.Labels[DefaultLabel]; ok {
+		d, err := parseDecision(value, fmt.Sprintf("namespace %s label %s", name, DefaultLabel))
+		return d, err == nil, err
+	}
+	if value, ok := namespace.Annotations[DefaultAnnotation]; ok {
+		d, err := parseDecision(value, fmt.Sprintf("namespace %s annotation %s", name, DefaultAnnotation))
+		return d, err == nil, err
+	}
+	return decision{}, false, nil
+}
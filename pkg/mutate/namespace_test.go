@@ -0,0 +1,117 @@
+package mutate
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func namespaceListers(t *testing.T, namespaces ...*corev1.Namespace) Listers {
+	var objects []runtime.Object
+	for _, namespace := range namespaces {
+		objects = append(objects, namespace)
+	}
+	client := fake.NewSimpleClientset(objects...)
+	factory := informers.NewSharedInformerFactory(client, 0)
+	listers := Listers{Namespaces: factory.Core().V1().Namespaces().Lister()}
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+	factory.Start(stopCh)
+	factory.WaitForCacheSync(stopCh)
+	return listers
+}
+
+func TestMutateNamespaceDefault(t *testing.T) {
+	listers := namespaceListers(t,
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "labelled", Labels: map[string]string{DefaultLabel: "true"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "annotated", Annotations: map[string]string{DefaultAnnotation: SkipValue}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "invalid", Labels: map[string]string{DefaultLabel: "maybe"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "plain"}},
+	)
+
+	tt := []struct {
+		name          string
+		namespace     string
+		annotations   map[string]string
+		expectedPatch []patch
+	}{
+		{
+			name:      "label",
+			namespace: "labelled",
+			expectedPatch: []patch{
+				{Op: "add", Path: "/spec/containers/0/securityContext/allowPrivilegeEscalation", Value: true},
+			},
+		},
+		{
+			name:      "annotation skip",
+			namespace: "annotated",
+		},
+		{
+			name:      "invalid value",
+			namespace: "invalid",
+			expectedPatch: []patch{
+				{Op: "add", Path: "/spec/containers/0/securityContext/allowPrivilegeEscalation", Value: false},
+			},
+		},
+		{
+			name:      "no label",
+			namespace: "plain",
+			expectedPatch: []patch{
+				{Op: "add", Path: "/spec/containers/0/securityContext/allowPrivilegeEscalation", Value: false},
+			},
+		},
+		{
+			name:      "not found",
+			namespace: "missing",
+			expectedPatch: []patch{
+				{Op: "add", Path: "/spec/containers/0/securityContext/allowPrivilegeEscalation", Value: false},
+			},
+		},
+		{
+			name:        "pod annotation wins",
+			namespace:   "labelled",
+			annotations: map[string]string{DefaultAnnotation: "false"},
+			expectedPatch: []patch{
+				{Op: "add", Path: "/spec/containers/0/securityContext/allowPrivilegeEscalation", Value: false},
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			input := pod(tc.namespace, []corev1.Container{}, []corev1.Container{containerSecurityContextEmpty})
+			input.Annotations = tc.annotations
+			podBytes, err := json.Marshal(input)
+			if err != nil {
+				t.Fatal("failed to json encode Pod")
+			}
+
+			admissionReview := admissionv1.AdmissionReview{}
+			admissionReview.TypeMeta = admissionReviewCreatePod.TypeMeta
+			admissionReview.Request = admissionReviewCreatePod.Request
+			admissionReview.Request.Object.Raw = podBytes
+			res, _ := mutate(&admissionReview, options{overridesEnabled: true, listers: listers}, log)
+
+			if tc.expectedPatch == nil {
+				if res.Patch != nil {
+					t.Errorf("expected no patch, got %s", res.Patch)
+				}
+				return
+			}
+			expectedBytes, err := json.Marshal(tc.expectedPatch)
+			if err != nil {
+				t.Fatal("failed to json encode patch")
+			}
+			if !bytes.Equal(expectedBytes, res.Patch) {
+				t.Errorf("expected patch %s, got %s", expectedBytes, res.Patch)
+			}
+		})
+	}
+}
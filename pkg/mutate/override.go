@@ -0,0 +1,86 @@
+package mutate
+
+import (
+	"defaultallowpe/pkg/admission"
+	"fmt"
+)
+
+// Annotations read from pod (template) metadata that override the configured default
+const (
+	AnnotationPrefix          = "default-allow-privilege-escalation.marshallford.me/"
+	DefaultAnnotation         = AnnotationPrefix + "default"
+	ContainerAnnotationPrefix = AnnotationPrefix + "container."
+)
+
+// defaultSource is the source of decisions made by the configured default
+const defaultSource = "app.default"
+
+// SkipValue leaves allowPrivilegeEscalation unset when used as an override
+const SkipValue = "skip"
+
+// decision is the resolved default for a container and the source it came from
+type decision struct {
+	skip   bool
+	value  bool
+	source string
+}
+
+func (d decision) String() string {
+	if d.skip {
+		return SkipValue
+	}
+	return fmt.Sprintf("%t", d.value)
+}
+
+func parseDecision(value string, source string) (decision, error) {
+	switch value {
+	case "true":
+		return decision{value: true, source: source}, nil
+	case "false":
+		return decision{value: false, source: source}, nil
+	case SkipValue:
+		return decision{skip: true, source: source}, nil
+	}
+	return decision{}, fmt.Errorf("%s has invalid value %q, expected true, false or %s", source, value, SkipValue)
+}
+
+// overridesPermitted checks if override annotations are honoured in the namespace
+func overridesPermitted(namespace string, opts options) bool {
+	if !opts.overridesEnabled {
+		return false
+	}
+	if len(opts.overrideNamespaces) == 0 {
+		return true
+	}
+	_, ok := admission.MatchGlob(opts.overrideNamespaces, namespace)
+	return ok
+}
+
+// annotationOverride looks up the container annotation followed by the pod annotation
+func annotationOverride(annotations map[string]string, c admission.Container) (string, string, bool) {
+	key := ContainerAnnotationPrefix + c.Name
+	if value, ok := annotations[key]; ok {
+		return key, value, true
+	}
+	if value, ok := annotations[DefaultAnnotation]; ok {
+		return DefaultAnnotation, value, true
+	}
+	return "", "", false
+}
+
+// resolve determines the default for a container by applying override annotations to the fallback decision,
+// a warning is returned when an override is ignored
+func resolve(pt *admission.PodTemplate, c admission.Container, d decision, opts options) (decision, string) {
+	key, value, ok := annotationOverride(pt.Template.Annotations, c)
+	if !ok {
+		return d, ""
+	}
+	if !overridesPermitted(pt.Meta.Namespace, opts) {
+		return d, fmt.Sprintf("annotation %s ignored, overrides are not permitted in namespace %q", key, pt.Meta.Namespace)
+	}
+	override, err := parseDecision(value, fmt.Sprintf("annotation %s", key))
+	if err != nil {
+		return d, fmt.Sprintf("%s, ignored", err)
+	}
+	return override, ""
+}
@@ -0,0 +1,106 @@
+package mutate
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestMutateOverrides(t *testing.T) {
+	tt := []struct {
+		name             string
+		annotations      map[string]string
+		opts             options
+		expectedPatch    []patch
+		expectedWarnings int
+		expectedAudit    map[string]string
+	}{
+		{
+			name:        "pod annotation",
+			annotations: map[string]string{DefaultAnnotation: "true"},
+			opts:        options{overridesEnabled: true},
+			expectedPatch: []patch{
+				{Op: "add", Path: "/spec/containers/0/securityContext/allowPrivilegeEscalation", Value: true},
+			},
+			expectedAudit: map[string]string{"override.foo": "true (annotation " + DefaultAnnotation + ")"},
+		},
+		{
+			name: "container annotation wins",
+			annotations: map[string]string{
+				DefaultAnnotation:                 "true",
+				ContainerAnnotationPrefix + "foo": SkipValue,
+			},
+			opts:          options{overridesEnabled: true},
+			expectedAudit: map[string]string{"override.foo": "skip (annotation " + ContainerAnnotationPrefix + "foo)"},
+		},
+		{
+			name:        "invalid value",
+			annotations: map[string]string{DefaultAnnotation: "maybe"},
+			opts:        options{overridesEnabled: true},
+			expectedPatch: []patch{
+				{Op: "add", Path: "/spec/containers/0/securityContext/allowPrivilegeEscalation", Value: false},
+			},
+			expectedWarnings: 1,
+		},
+		{
+			name:        "overrides disabled",
+			annotations: map[string]string{DefaultAnnotation: "true"},
+			opts:        options{overridesEnabled: false},
+			expectedPatch: []patch{
+				{Op: "add", Path: "/spec/containers/0/securityContext/allowPrivilegeEscalation", Value: false},
+			},
+			expectedWarnings: 1,
+		},
+		{
+			name:        "namespace not permitted",
+			annotations: map[string]string{DefaultAnnotation: "true"},
+			opts:        options{overridesEnabled: true, overrideNamespaces: []string{"team-*"}},
+			expectedPatch: []patch{
+				{Op: "add", Path: "/spec/containers/0/securityContext/allowPrivilegeEscalation", Value: false},
+			},
+			expectedWarnings: 1,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			input := pod("default", []corev1.Container{}, []corev1.Container{containerSecurityContextEmpty})
+			input.Annotations = tc.annotations
+			podBytes, err := json.Marshal(input)
+			if err != nil {
+				t.Fatal("failed to json encode Pod")
+			}
+
+			admissionReview := admissionv1.AdmissionReview{}
+			admissionReview.TypeMeta = admissionReviewCreatePod.TypeMeta
+			admissionReview.Request = admissionReviewCreatePod.Request
+			admissionReview.Request.Object.Raw = podBytes
+			res, _ := mutate(&admissionReview, tc.opts, log)
+
+			if tc.expectedPatch == nil {
+				if res.Patch != nil {
+					t.Errorf("expected no patch, got %s", res.Patch)
+				}
+			} else {
+				expectedBytes, err := json.Marshal(tc.expectedPatch)
+				if err != nil {
+					t.Fatal("failed to json encode patch")
+				}
+				if !bytes.Equal(expectedBytes, res.Patch) {
+					t.Errorf("expected patch %s, got %s", expectedBytes, res.Patch)
+				}
+			}
+			if len(res.Warnings) != tc.expectedWarnings {
+				t.Errorf("expected %d warnings, got %v", tc.expectedWarnings, res.Warnings)
+			}
+			for key, expected := range tc.expectedAudit {
+				if res.AuditAnnotations[key] != expected {
+					t.Errorf("expected audit annotation %s=%s, got %s", key, expected, res.AuditAnnotations[key])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package mutate
+
+import (
+	"defaultallowpe/pkg/admission"
+	"defaultallowpe/pkg/policy"
+)
+
+// policyDefault determines the default for a container before override annotations are applied, the precedence
+// is NamespaceDefaultingPolicy, then the namespace label or annotation, then DefaultingPolicy and finally the
+// fallback from an image rule or app.default
+func policyDefault(store *policy.Store, pt *admission.PodTemplate, c admission.Container, namespaceDefault *decision, fallback decision) (decision, []policy.Reference) {
+	d := fallback
+	if store == nil {
+		if namespaceDefault != nil {
+			d = *namespaceDefault
+		}
+		return d, nil
+	}
+
+	var refs []policy.Reference
+	namespaced, cluster := store.Match(pt.Meta.Namespace, pt.Template.Labels, c.Name, c.Image)
+	if cluster != nil {
+		refs = append(refs, cluster.Reference)
+		d = policyDecision(cluster)
+	}
+	if namespaceDefault != nil {
+		d = *namespaceDefault
+	}
+	if namespaced != nil {
+		refs = append(refs, namespaced.Reference)
+		d = policyDecision(namespaced)
+	}
+	return d, refs
+}
+
+// policyDecision converts a policy match, the default has been validated by the store
+func policyDecision(m *policy.Match) decision {
+	d, _ := parseDecision(m.Default, m.Reference.String())
+	return d
+}
+
+// recordMatches counts the admission for each matched policy
+func recordMatches(store *policy.Store, matched map[policy.Reference]bool) {
+	if store == nil || len(matched) == 0 {
+		return
+	}
+	refs := make([]policy.Reference, 0, len(matched))
+	for ref := range matched {
+		refs = append(refs, ref)
+	}
+	store.Record(refs...)
+}
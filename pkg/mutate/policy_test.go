@@ -0,0 +1,81 @@
+package mutate
+
+import (
+	"bytes"
+	"defaultallowpe/pkg/policy"
+	"encoding/json"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMutatePolicyPrecedence(t *testing.T) {
+	namespaces := namespaceListers(t,
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "labelled", Labels: map[string]string{DefaultLabel: "true"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "plain"}},
+	).Namespaces
+	store := policy.NewStore(namespaces)
+	store.Set(&policy.Policy{
+		TypeMeta:   metav1.TypeMeta{Kind: policy.ClusterKind},
+		ObjectMeta: metav1.ObjectMeta{Name: "cluster"},
+		Spec:       policy.Spec{Default: SkipValue},
+	})
+	store.Set(&policy.Policy{
+		TypeMeta:   metav1.TypeMeta{Kind: policy.NamespacedKind},
+		ObjectMeta: metav1.ObjectMeta{Name: "namespaced", Namespace: "labelled"},
+		Spec:       policy.Spec{Default: "false", Containers: []string{"bar"}},
+	})
+	listers := Listers{Namespaces: namespaces, Policies: store}
+
+	bar := containerSecurityContextEmpty
+	bar.Name = "bar"
+	tt := []struct {
+		name          string
+		namespace     string
+		expectedPatch []patch
+	}{
+		{
+			name:      "cluster policy",
+			namespace: "plain",
+		},
+		{
+			name:      "namespace label over cluster policy, namespaced policy over namespace label",
+			namespace: "labelled",
+			expectedPatch: []patch{
+				{Op: "add", Path: "/spec/containers/0/securityContext/allowPrivilegeEscalation", Value: true},
+				{Op: "add", Path: "/spec/containers/1/securityContext/allowPrivilegeEscalation", Value: false},
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			podBytes, err := json.Marshal(pod(tc.namespace, []corev1.Container{}, []corev1.Container{containerSecurityContextEmpty, bar}))
+			if err != nil {
+				t.Fatal("failed to json encode Pod")
+			}
+
+			admissionReview := admissionv1.AdmissionReview{}
+			admissionReview.TypeMeta = admissionReviewCreatePod.TypeMeta
+			admissionReview.Request = admissionReviewCreatePod.Request
+			admissionReview.Request.Object.Raw = podBytes
+			res, _ := mutate(&admissionReview, options{listers: listers}, log)
+
+			if tc.expectedPatch == nil {
+				if res.Patch != nil {
+					t.Errorf("expected no patch, got %s", res.Patch)
+				}
+				return
+			}
+			expectedBytes, err := json.Marshal(tc.expectedPatch)
+			if err != nil {
+				t.Fatal("failed to json encode patch")
+			}
+			if !bytes.Equal(expectedBytes, res.Patch) {
+				t.Errorf("expected patch %s, got %s", expectedBytes, res.Patch)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package mutate
+
+import (
+	"defaultallowpe/pkg/config"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+)
+
+// Pod Security Admission labels on a Namespace read by the restricted profile
+const (
+	EnforceLabel        = "pod-security.kubernetes.io/enforce"
+	EnforceVersionLabel = "pod-security.kubernetes.io/enforce-version"
+)
+
+// RestrictedLevel is the Pod Security Standards level remediated by the profile
+const RestrictedLevel = "restricted"
+
+// LatestVersion of the Pod Security Standards, also used when the version label is missing or invalid
+const LatestVersion = "latest"
+
+// minor versions of the Pod Security Standards that introduced the requirements of the restricted level
+const (
+	allowPrivilegeEscalationSince = 8
+	seccompProfileSince           = 19
+	dropCapabilitiesSince         = 22
+)
+
+var versionPattern = regexp.MustCompile(`^v1\.(0|[1-9][0-9]*)$`)
+
+// profile is the restricted level enforced on a namespace at a version of the Pod Security Standards
+type profile struct {
+	version string
+	minor   int
+}
+
+func (p profile) String() string {
+	return fmt.Sprintf("%s:%s", RestrictedLevel, p.version)
+}
+
+// requires checks if the profile version includes a requirement introduced in a minor version
+func (p profile) requires(since int) bool {
+	return p.version == LatestVersion || p.minor >= since
+}
+
+// defaulters adds the fields required by the profile version to the configured defaulters
+func (p profile) defaulters(d config.Defaulters) config.Defaulters {
+	d.RunAsNonRoot = config.BoolDefaulter{Enabled: true, Value: true}
+	if p.requires(seccompProfileSince) {
+		d.SeccompProfile = config.StringDefaulter{Enabled: true, Value: string(corev1.SeccompProfileTypeRuntimeDefault)}
+	}
+	if p.requires(dropCapabilitiesSince) {
+		d.DropCapabilities = config.ListDefaulter{Enabled: true, Value: []string{"ALL"}}
+	}
+	return d
+}
+
+// decide forces allowPrivilegeEscalation to false when the profile version requires it
+func (p profile) decide(d decision) decision {
+	if !p.requires(allowPrivilegeEscalationSince) || (!d.skip && !d.value) {
+		return d
+	}
+	return decision{value: false, source: fmt.Sprintf("profile %s", p)}
+}
+
+// namespaceProfile looks up whether Pod Security Admission enforces the restricted level on a namespace
+func namespaceProfile(lister corelisters.NamespaceLister, name string) (*profile, error) {
+	if lister == nil {
+		return nil, nil
+	}
+	namespace, err := lister.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	if namespace.Labels[EnforceLabel] != RestrictedLevel {
+		return nil, nil
+	}
+	p := profile{version: LatestVersion}
+	if match := versionPattern.FindStringSubmatch(namespace.Labels[EnforceVersionLabel]); match != nil {
+		p.version = match[0]
+		p.minor, _ = strconv.Atoi(match[1])
+	}
+	return &p, nil
+}
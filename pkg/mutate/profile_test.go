@@ -0,0 +1,107 @@
+package mutate
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMutateRestrictedProfile(t *testing.T) {
+	listers := namespaceListers(t,
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "latest", Labels: map[string]string{EnforceLabel: RestrictedLevel}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "v1-21", Labels: map[string]string{EnforceLabel: RestrictedLevel, EnforceVersionLabel: "v1.21"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "v1-18", Labels: map[string]string{EnforceLabel: RestrictedLevel, EnforceVersionLabel: "v1.18"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "invalid-version", Labels: map[string]string{EnforceLabel: RestrictedLevel, EnforceVersionLabel: "1.22"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "baseline", Labels: map[string]string{EnforceLabel: "baseline", DefaultLabel: "true"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "restricted-default", Labels: map[string]string{EnforceLabel: RestrictedLevel, DefaultLabel: SkipValue}}},
+	)
+	latest := []patch{
+		{Op: "add", Path: "/spec/containers/0/securityContext/allowPrivilegeEscalation", Value: false},
+		{Op: "add", Path: "/spec/containers/0/securityContext/runAsNonRoot", Value: true},
+		{Op: "add", Path: "/spec/containers/0/securityContext/capabilities", Value: struct{}{}},
+		{Op: "add", Path: "/spec/containers/0/securityContext/capabilities/drop", Value: []string{"ALL"}},
+		{Op: "add", Path: "/spec/containers/0/securityContext/seccompProfile", Value: map[string]string{"type": "RuntimeDefault"}},
+	}
+
+	tt := []struct {
+		name          string
+		namespace     string
+		disabled      bool
+		expectedPatch []patch
+	}{
+		{
+			name:          "latest",
+			namespace:     "latest",
+			expectedPatch: latest,
+		},
+		{
+			name:      "before drop capabilities",
+			namespace: "v1-21",
+			expectedPatch: []patch{
+				{Op: "add", Path: "/spec/containers/0/securityContext/allowPrivilegeEscalation", Value: false},
+				{Op: "add", Path: "/spec/containers/0/securityContext/runAsNonRoot", Value: true},
+				{Op: "add", Path: "/spec/containers/0/securityContext/seccompProfile", Value: map[string]string{"type": "RuntimeDefault"}},
+			},
+		},
+		{
+			name:      "before seccomp",
+			namespace: "v1-18",
+			expectedPatch: []patch{
+				{Op: "add", Path: "/spec/containers/0/securityContext/allowPrivilegeEscalation", Value: false},
+				{Op: "add", Path: "/spec/containers/0/securityContext/runAsNonRoot", Value: true},
+			},
+		},
+		{
+			name:          "invalid version is latest",
+			namespace:     "invalid-version",
+			expectedPatch: latest,
+		},
+		{
+			name:      "other level",
+			namespace: "baseline",
+			expectedPatch: []patch{
+				{Op: "add", Path: "/spec/containers/0/securityContext/allowPrivilegeEscalation", Value: true},
+			},
+		},
+		{
+			name:          "profile wins over namespace default",
+			namespace:     "restricted-default",
+			expectedPatch: latest,
+		},
+		{
+			name:      "disabled",
+			namespace: "latest",
+			disabled:  true,
+			expectedPatch: []patch{
+				{Op: "add", Path: "/spec/containers/0/securityContext/allowPrivilegeEscalation", Value: false},
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			podBytes, err := json.Marshal(pod(tc.namespace, []corev1.Container{}, []corev1.Container{containerSecurityContextEmpty}))
+			if err != nil {
+				t.Fatal("failed to json encode Pod")
+			}
+
+			admissionReview := admissionv1.AdmissionReview{}
+			admissionReview.TypeMeta = admissionReviewCreatePod.TypeMeta
+			admissionReview.Request = admissionReviewCreatePod.Request
+			admissionReview.Request.Object.Raw = podBytes
+			res, _ := mutate(&admissionReview, options{restrictedProfile: !tc.disabled, listers: listers}, log)
+
+			expectedBytes, err := json.Marshal(tc.expectedPatch)
+			if err != nil {
+				t.Fatal("failed to json encode patch")
+			}
+			if !bytes.Equal(expectedBytes, res.Patch) {
+				t.Errorf("expected patch %s, got %s", expectedBytes, res.Patch)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Controller watches policy custom resources into a store and reports their status
+type Controller struct {
+	client         dynamic.Interface
+	store          *Store
+	log            *zap.SugaredLogger
+	statusInterval time.Duration
+	synced         []cache.InformerSynced
+}
+
+// NewController creates a policy controller
+func NewController(client dynamic.Interface, store *Store, log *zap.SugaredLogger, statusInterval time.Duration) *Controller {
+	return &Controller{
+		client:         client,
+		store:          store,
+		log:            log,
+		statusInterval: statusInterval,
+	}
+}
+
+// Start runs the policy informers, waits for their caches to sync and periodically updates policy status
+func (c *Controller) Start(stopCh <-chan struct{}) error {
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(c.client, 0)
+	for _, gvr := range []schema.GroupVersionResource{ClusterResource, NamespacedResource} {
+		informer := factory.ForResource(gvr).Informer()
+		informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    c.set,
+			UpdateFunc: func(_, obj interface{}) { c.set(obj) },
+			DeleteFunc: c.delete,
+		})
+		c.synced = append(c.synced, informer.HasSynced)
+	}
+	factory.Start(stopCh)
+	for gvr, synced := range factory.WaitForCacheSync(stopCh) {
+		if !synced {
+			return fmt.Errorf("%s informer cache failed to sync", gvr.Resource)
+		}
+	}
+	go wait.Until(func() { c.syncStatus(context.Background()) }, c.statusInterval, stopCh)
+	return nil
+}
+
+// HasSynced reports whether the policy informer caches have synced
+func (c *Controller) HasSynced() bool {
+	if len(c.synced) == 0 {
+		return false
+	}
+	for _, synced := range c.synced {
+		if !synced() {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Controller) set(obj interface{}) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	var p Policy
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, &p); err != nil {
+		c.log.Warnw("unable to convert policy",
+			"policy", u.GetName(),
+			"err", err,
+		)
+		return
+	}
+	c.store.Set(&p)
+}
+
+func (c *Controller) delete(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	c.store.Delete(Reference{Kind: u.GetKind(), Namespace: u.GetNamespace(), Name: u.GetName()})
+}
+
+// desiredStatus computes the status of a stored policy, adding the admissions matched by this replica to the count
+func desiredStatus(e entry) Status {
+	status := Status{
+		Conditions:        append([]metav1.Condition(nil), e.policy.Status.Conditions...),
+		MatchedAdmissions: e.policy.Status.MatchedAdmissions + e.pending,
+	}
+	condition := metav1.Condition{
+		Type:               ParsedCondition,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: e.policy.Generation,
+		Reason:             "Valid",
+		Message:            "policy parsed",
+	}
+	if e.err != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "Invalid"
+		condition.Message = e.err.Error()
+	}
+	meta.SetStatusCondition(&status.Conditions, condition)
+	return status
+}
+
+func statusEqual(a Status, b Status) bool {
+	if a.MatchedAdmissions != b.MatchedAdmissions {
+		return false
+	}
+	current := meta.FindStatusCondition(a.Conditions, ParsedCondition)
+	desired := meta.FindStatusCondition(b.Conditions, ParsedCondition)
+	if current == nil || desired == nil {
+		return current == desired
+	}
+	return current.Status == desired.Status &&
+		current.Reason == desired.Reason &&
+		current.Message == desired.Message &&
+		current.ObservedGeneration == desired.ObservedGeneration
+}
+
+// syncStatus updates the status of policies whose status is out of date. Replicas share the admission count, an
+// update based on a stale resourceVersion conflicts and the pending admissions are added on the next sync.
+func (c *Controller) syncStatus(ctx context.Context) {
+	for _, e := range c.store.snapshot() {
+		status := desiredStatus(e)
+		if statusEqual(e.policy.Status, status) {
+			continue
+		}
+		p := *e.policy
+		p.Status = status
+		obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&p)
+		if err != nil {
+			c.log.Warnw("unable to convert policy",
+				"policy", p.reference().String(),
+				"err", err,
+			)
+			continue
+		}
+		gvr := ClusterResource
+		if p.Kind == NamespacedKind {
+			gvr = NamespacedResource
+		}
+		updated, err := c.client.Resource(gvr).Namespace(p.Namespace).UpdateStatus(ctx, &unstructured.Unstructured{Object: obj}, metav1.UpdateOptions{})
+		if apierrors.IsConflict(err) {
+			c.log.Debugw("policy status changed concurrently, retrying on next sync",
+				"policy", p.reference().String(),
+			)
+			continue
+		}
+		if err != nil {
+			c.log.Warnw("unable to update policy status",
+				"policy", p.reference().String(),
+				"err", err,
+			)
+			continue
+		}
+		c.set(updated)
+		c.store.reported(p.reference(), p.UID, e.pending)
+	}
+}
@@ -0,0 +1,163 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func unstructuredPolicy(t *testing.T, p *Policy) *unstructured.Unstructured {
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &unstructured.Unstructured{Object: obj}
+}
+
+func TestControllerStatus(t *testing.T) {
+	valid := newPolicy(ClusterKind, "", "valid", Spec{Default: "true"})
+	invalid := newPolicy(NamespacedKind, "default", "invalid", Spec{Default: "maybe"})
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		ClusterResource:    ClusterKind + "List",
+		NamespacedResource: NamespacedKind + "List",
+	}, unstructuredPolicy(t, valid), unstructuredPolicy(t, invalid))
+
+	store := NewStore(nil)
+	controller := NewController(client, store, zap.NewNop().Sugar(), time.Hour)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	if err := controller.Start(stopCh); err != nil {
+		t.Fatal(err)
+	}
+	if !controller.HasSynced() {
+		t.Error("expected synced true, got synced false")
+	}
+
+	store.Record(valid.reference(), valid.reference())
+	controller.syncStatus(context.Background())
+
+	tt := []struct {
+		name              string
+		gvr               schema.GroupVersionResource
+		policy            *Policy
+		expectedCondition metav1.ConditionStatus
+		expectedMatched   int64
+	}{
+		{
+			name:              "valid",
+			gvr:               ClusterResource,
+			policy:            valid,
+			expectedCondition: metav1.ConditionTrue,
+			expectedMatched:   2,
+		},
+		{
+			name:              "invalid",
+			gvr:               NamespacedResource,
+			policy:            invalid,
+			expectedCondition: metav1.ConditionFalse,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			u, err := client.Resource(tc.gvr).Namespace(tc.policy.Namespace).Get(context.Background(), tc.policy.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var p Policy
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, &p); err != nil {
+				t.Fatal(err)
+			}
+			condition := meta.FindStatusCondition(p.Status.Conditions, ParsedCondition)
+			if condition == nil {
+				t.Fatal("expected Parsed condition, got none")
+			}
+			if condition.Status != tc.expectedCondition {
+				t.Errorf("expected condition status %s, got %s", tc.expectedCondition, condition.Status)
+			}
+			if p.Status.MatchedAdmissions != tc.expectedMatched {
+				t.Errorf("expected matched admissions %d, got %d", tc.expectedMatched, p.Status.MatchedAdmissions)
+			}
+		})
+	}
+}
+
+func TestControllerStatusReplicas(t *testing.T) {
+	valid := unstructuredPolicy(t, newPolicy(ClusterKind, "", "valid", Spec{Default: "true"}))
+	valid.SetResourceVersion("1")
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		ClusterResource:    ClusterKind + "List",
+		NamespacedResource: NamespacedKind + "List",
+	}, valid)
+
+	// reject status updates based on a stale resourceVersion like the API server
+	var mu sync.Mutex
+	version := 1
+	client.PrependReactor("update", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		u := action.(k8stesting.UpdateAction).GetObject().(*unstructured.Unstructured)
+		if u.GetResourceVersion() != strconv.Itoa(version) {
+			return true, nil, apierrors.NewConflict(action.GetResource().GroupResource(), u.GetName(), errors.New("stale resourceVersion"))
+		}
+		version++
+		u.SetResourceVersion(strconv.Itoa(version))
+		return false, nil, nil
+	})
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	var stores []*Store
+	var controllers []*Controller
+	for i := 0; i < 2; i++ {
+		store := NewStore(nil)
+		controller := NewController(client, store, zap.NewNop().Sugar(), time.Hour)
+		if err := controller.Start(stopCh); err != nil {
+			t.Fatal(err)
+		}
+		stores = append(stores, store)
+		controllers = append(controllers, controller)
+	}
+
+	ref := Reference{Kind: ClusterKind, Name: valid.GetName()}
+	stores[0].Record(ref, ref)
+	stores[1].Record(ref, ref, ref)
+	var count int64
+	if err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		for _, controller := range controllers {
+			controller.syncStatus(context.Background())
+		}
+		u, err := client.Resource(ClusterResource).Get(context.Background(), valid.GetName(), metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		count, _, _ = unstructured.NestedInt64(u.Object, "status", "matchedAdmissions")
+		return count == 5, nil
+	}); err != nil {
+		t.Fatalf("expected matched admissions of both replicas %d, got %d", 5, count)
+	}
+
+	// nothing is counted twice once the replicas observe the status
+	time.Sleep(100 * time.Millisecond)
+	for _, controller := range controllers {
+		controller.syncStatus(context.Background())
+	}
+	u, _ := client.Resource(ClusterResource).Get(context.Background(), valid.GetName(), metav1.GetOptions{})
+	if count, _, _ := unstructured.NestedInt64(u.Object, "status", "matchedAdmissions"); count != 5 {
+		t.Errorf("expected matched admissions %d, got %d", 5, count)
+	}
+}
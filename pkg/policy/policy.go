@@ -0,0 +1,145 @@
+package policy
+
+import (
+	"fmt"
+	"path"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Group and version of the policy custom resources
+const (
+	Group   = "default-allow-privilege-escalation.marshallford.me"
+	Version = "v1alpha1"
+)
+
+// Kinds of the policy custom resources
+const (
+	ClusterKind    = "DefaultingPolicy"
+	NamespacedKind = "NamespaceDefaultingPolicy"
+)
+
+// Resources of the policy custom resources
+var (
+	ClusterResource    = schema.GroupVersionResource{Group: Group, Version: Version, Resource: "defaultingpolicies"}
+	NamespacedResource = schema.GroupVersionResource{Group: Group, Version: Version, Resource: "namespacedefaultingpolicies"}
+)
+
+// ParsedCondition is the status condition reporting whether a policy could be parsed
+const ParsedCondition = "Parsed"
+
+// Policy is a DefaultingPolicy (cluster-scoped) or NamespaceDefaultingPolicy
+type Policy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   Spec   `json:"spec"`
+	Status Status `json:"status,omitempty"`
+}
+
+// Spec selects containers and the default applied to them
+type Spec struct {
+	// NamespaceSelector matches namespace labels, only supported by DefaultingPolicy
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// PodSelector matches pod (template) labels
+	PodSelector *metav1.LabelSelector `json:"podSelector,omitempty"`
+	// Containers are container name globs, all containers match when empty
+	Containers []string `json:"containers,omitempty"`
+	// Images are container image globs, all images match when empty
+	Images []string `json:"images,omitempty"`
+	// Default is true, false or skip
+	Default string `json:"default"`
+}
+
+// Status reports parsing and usage of a policy
+type Status struct {
+	Conditions        []metav1.Condition `json:"conditions,omitempty"`
+	MatchedAdmissions int64              `json:"matchedAdmissions"`
+}
+
+// Reference identifies a policy
+type Reference struct {
+	Kind      string
+	Namespace string
+	Name      string
+}
+
+func (r Reference) String() string {
+	if r.Namespace == "" {
+		return fmt.Sprintf("%s %s", r.Kind, r.Name)
+	}
+	return fmt.Sprintf("%s %s/%s", r.Kind, r.Namespace, r.Name)
+}
+
+// Match is the policy that matched a container and its default
+type Match struct {
+	Reference Reference
+	Default   string
+}
+
+// parsed is a policy with its selectors compiled
+type parsed struct {
+	namespaceSelector labels.Selector
+	podSelector       labels.Selector
+}
+
+func (p *Policy) reference() Reference {
+	return Reference{Kind: p.Kind, Namespace: p.Namespace, Name: p.Name}
+}
+
+func selector(ls *metav1.LabelSelector) (labels.Selector, error) {
+	if ls == nil {
+		return labels.Everything(), nil
+	}
+	return metav1.LabelSelectorAsSelector(ls)
+}
+
+func validGlobs(field string, patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("spec.%s: invalid pattern %q", field, pattern)
+		}
+	}
+	return nil
+}
+
+// parse validates a policy and compiles its selectors
+func parse(p *Policy) (*parsed, error) {
+	switch p.Spec.Default {
+	case "true", "false", "skip":
+	default:
+		return nil, fmt.Errorf("spec.default: invalid value %q, expected true, false or skip", p.Spec.Default)
+	}
+	if p.Kind == NamespacedKind && p.Spec.NamespaceSelector != nil {
+		return nil, fmt.Errorf("spec.namespaceSelector: not supported by %s", NamespacedKind)
+	}
+	if err := validGlobs("containers", p.Spec.Containers); err != nil {
+		return nil, err
+	}
+	if err := validGlobs("images", p.Spec.Images); err != nil {
+		return nil, err
+	}
+	namespaceSelector, err := selector(p.Spec.NamespaceSelector)
+	if err != nil {
+		return nil, fmt.Errorf("spec.namespaceSelector: %w", err)
+	}
+	podSelector, err := selector(p.Spec.PodSelector)
+	if err != nil {
+		return nil, fmt.Errorf("spec.podSelector: %w", err)
+	}
+	return &parsed{namespaceSelector: namespaceSelector, podSelector: podSelector}, nil
+}
+
+func matchGlobs(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, value); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,160 @@
+package policy
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newPolicy(kind string, namespace string, name string, spec Spec) *Policy {
+	return &Policy{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: Group + "/" + Version,
+			Kind:       kind,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			UID:       types.UID("uid-" + name),
+		},
+		Spec: spec,
+	}
+}
+
+func TestParse(t *testing.T) {
+	tt := []struct {
+		name     string
+		input    *Policy
+		expected string
+	}{
+		{
+			name:  "valid",
+			input: newPolicy(ClusterKind, "", "valid", Spec{Default: "skip", Images: []string{"docker.io/*"}}),
+		},
+		{
+			name:     "invalid default",
+			input:    newPolicy(ClusterKind, "", "invalid", Spec{Default: "maybe"}),
+			expected: `spec.default: invalid value "maybe", expected true, false or skip`,
+		},
+		{
+			name:     "namespace selector",
+			input:    newPolicy(NamespacedKind, "default", "invalid", Spec{Default: "true", NamespaceSelector: &metav1.LabelSelector{}}),
+			expected: "spec.namespaceSelector: not supported by NamespaceDefaultingPolicy",
+		},
+		{
+			name:     "invalid glob",
+			input:    newPolicy(ClusterKind, "", "invalid", Spec{Default: "true", Containers: []string{"["}}),
+			expected: `spec.containers: invalid pattern "["`,
+		},
+		{
+			name: "invalid selector",
+			input: newPolicy(ClusterKind, "", "invalid", Spec{Default: "true", PodSelector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "app", Operator: "Maybe"}},
+			}}),
+			expected: `spec.podSelector: "Maybe" is not a valid pod selector operator`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parse(tc.input)
+			var message string
+			if err != nil {
+				message = err.Error()
+			}
+			if message != tc.expected {
+				t.Errorf("expected error %s, got %s", tc.expected, message)
+			}
+		})
+	}
+}
+
+func TestStoreMatch(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:   "team-a",
+		Labels: map[string]string{"team": "a"},
+	}})
+	factory := informers.NewSharedInformerFactory(client, 0)
+	store := NewStore(factory.Core().V1().Namespaces().Lister())
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	factory.Start(stopCh)
+	factory.WaitForCacheSync(stopCh)
+
+	store.Set(newPolicy(ClusterKind, "", "b-team-a", Spec{
+		Default:           "true",
+		NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}},
+	}))
+	store.Set(newPolicy(ClusterKind, "", "a-vendor", Spec{
+		Default: "skip",
+		Images:  []string{"vendor.example.com/*"},
+	}))
+	store.Set(newPolicy(ClusterKind, "", "invalid", Spec{Default: "maybe"}))
+	store.Set(newPolicy(NamespacedKind, "team-a", "sidecars", Spec{
+		Default:     "false",
+		Containers:  []string{"*-sidecar"},
+		PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+	}))
+
+	tt := []struct {
+		name               string
+		namespace          string
+		podLabels          map[string]string
+		container          string
+		image              string
+		expectedNamespaced string
+		expectedCluster    string
+	}{
+		{
+			name:            "namespace selector",
+			namespace:       "team-a",
+			container:       "app",
+			image:           "app:1",
+			expectedCluster: "b-team-a",
+		},
+		{
+			name:            "first in name order",
+			namespace:       "team-a",
+			container:       "app",
+			image:           "vendor.example.com/app:1",
+			expectedCluster: "a-vendor",
+		},
+		{
+			name:               "namespaced",
+			namespace:          "team-a",
+			podLabels:          map[string]string{"app": "web"},
+			container:          "log-sidecar",
+			image:              "sidecar:1",
+			expectedNamespaced: "sidecars",
+			expectedCluster:    "b-team-a",
+		},
+		{
+			name:      "no match",
+			namespace: "default",
+			container: "app",
+			image:     "app:1",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			namespaced, cluster := store.Match(tc.namespace, tc.podLabels, tc.container, tc.image)
+			name := func(m *Match) string {
+				if m == nil {
+					return ""
+				}
+				return m.Reference.Name
+			}
+			if name(namespaced) != tc.expectedNamespaced {
+				t.Errorf("expected namespaced policy %s, got %s", tc.expectedNamespaced, name(namespaced))
+			}
+			if name(cluster) != tc.expectedCluster {
+				t.Errorf("expected cluster policy %s, got %s", tc.expectedCluster, name(cluster))
+			}
+		})
+	}
+}
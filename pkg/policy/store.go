@@ -0,0 +1,141 @@
+package policy
+
+import (
+	"sort"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	corelisters "k8s.io/client-go/listers/core/v1"
+)
+
+// entry is a stored policy with its parse result and the admissions matched since its status was last updated
+type entry struct {
+	policy  *Policy
+	parsed  *parsed
+	err     error
+	pending int64
+}
+
+// Store holds the current policies and evaluates them against admitted containers
+type Store struct {
+	namespaces corelisters.NamespaceLister
+
+	mu      sync.RWMutex
+	entries map[Reference]*entry
+}
+
+// NewStore creates a policy store, the namespace lister is required for namespace selectors
+func NewStore(namespaces corelisters.NamespaceLister) *Store {
+	return &Store{
+		namespaces: namespaces,
+		entries:    map[Reference]*entry{},
+	}
+}
+
+// Set adds or replaces a policy, admissions not yet added to its status are carried over
+func (s *Store) Set(p *Policy) {
+	parsed, err := parse(p)
+	ref := p.reference()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var pending int64
+	if existing, ok := s.entries[ref]; ok && existing.policy.UID == p.UID {
+		pending = existing.pending
+	}
+	s.entries[ref] = &entry{policy: p, parsed: parsed, err: err, pending: pending}
+}
+
+// reported removes admissions added to the status of a policy from its pending count
+func (s *Store) reported(ref Reference, uid types.UID, n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.entries[ref]; ok && e.policy.UID == uid {
+		e.pending -= n
+	}
+}
+
+// Delete removes a policy
+func (s *Store) Delete(ref Reference) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, ref)
+}
+
+// snapshot copies the stored entries
+func (s *Store) snapshot() []entry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entries := make([]entry, 0, len(s.entries))
+	for _, e := range s.entries {
+		entries = append(entries, *e)
+	}
+	return entries
+}
+
+// Record counts an admission for each matched policy
+func (s *Store) Record(refs ...Reference) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, ref := range refs {
+		if e, ok := s.entries[ref]; ok {
+			e.pending++
+		}
+	}
+}
+
+// sorted lists the valid entries of a kind ordered by namespace and name
+func (s *Store) sorted(kind string, namespace string) []*entry {
+	var entries []*entry
+	for ref, e := range s.entries {
+		if ref.Kind != kind || e.err != nil {
+			continue
+		}
+		if kind == NamespacedKind && ref.Namespace != namespace {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].policy.Name < entries[j].policy.Name
+	})
+	return entries
+}
+
+// Match evaluates the policies for a container, returning the first matching NamespaceDefaultingPolicy and
+// DefaultingPolicy in name order
+func (s *Store) Match(namespace string, podLabels map[string]string, container string, image string) (*Match, *Match) {
+	var namespaceLabels labels.Set
+	if s.namespaces != nil {
+		if ns, err := s.namespaces.Get(namespace); err == nil {
+			namespaceLabels = ns.Labels
+		}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	match := func(e *entry, checkNamespace bool) bool {
+		if checkNamespace && !e.parsed.namespaceSelector.Matches(namespaceLabels) {
+			return false
+		}
+		return e.parsed.podSelector.Matches(labels.Set(podLabels)) &&
+			matchGlobs(e.policy.Spec.Containers, container) &&
+			matchGlobs(e.policy.Spec.Images, image)
+	}
+
+	var namespaced, cluster *Match
+	for _, e := range s.sorted(NamespacedKind, namespace) {
+		if match(e, false) {
+			namespaced = &Match{Reference: e.policy.reference(), Default: e.policy.Spec.Default}
+			break
+		}
+	}
+	for _, e := range s.sorted(ClusterKind, "") {
+		if match(e, true) {
+			cluster = &Match{Reference: e.policy.reference(), Default: e.policy.Spec.Default}
+			break
+		}
+	}
+	return namespaced, cluster
+}
@@ -0,0 +1,245 @@
+package psp
+
+import (
+	"defaultallowpe/pkg/mutate"
+	"defaultallowpe/pkg/policy"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// Outputs of the psp-import command
+const (
+	OutputConfig = "config"
+	OutputPolicy = "policy"
+)
+
+// Name of the psp-import command
+const Name = "psp-import"
+
+// SelectorLabel selects the pods a DefaultingPolicy emitted for a PodSecurityPolicy applies to, its value is the PSP name
+const SelectorLabel = mutate.AnnotationPrefix + "psp"
+
+// Command runs psp-import with its arguments and returns the exit code
+func Command(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet(Name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	output := flags.String("output", OutputConfig, "emit webhook config (config) or DefaultingPolicy objects (policy)")
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: %s [-output config|policy] [file ...]\n\nReads PodSecurityPolicy YAML from files, or stdin when none or - is given.\n\n", Name)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *output != OutputConfig && *output != OutputPolicy {
+		fmt.Fprintf(stderr, "%s: invalid output %q, expected config or policy\n", Name, *output)
+		return 2
+	}
+
+	files := flags.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	var translations []Translation
+	for _, file := range files {
+		t, err := readFile(file, stdin)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %s\n", Name, err)
+			return 1
+		}
+		translations = append(translations, t...)
+	}
+
+	var out []byte
+	var notes []string
+	var err error
+	if *output == OutputPolicy {
+		out, notes, err = policies(translations)
+	} else {
+		out, notes, err = configFragment(translations)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %s\n", Name, err)
+		return 1
+	}
+	for _, t := range translations {
+		for _, u := range t.Untranslated {
+			fmt.Fprintf(stderr, "untranslated: %s %s: %s\n", Kind, t.Name, u)
+		}
+	}
+	for _, note := range notes {
+		fmt.Fprintf(stderr, "note: %s\n", note)
+	}
+	if _, err := stdout.Write(out); err != nil {
+		fmt.Fprintf(stderr, "%s: %s\n", Name, err)
+		return 1
+	}
+	return 0
+}
+
+func readFile(file string, stdin io.Reader) ([]Translation, error) {
+	if file == "-" {
+		t, err := Read(stdin)
+		if err != nil {
+			return nil, fmt.Errorf("stdin: %w", err)
+		}
+		return t, nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	t, err := Read(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return t, nil
+}
+
+// settings are the config keys and values equivalent to a translation
+func settings(t Translation) map[string]interface{} {
+	s := map[string]interface{}{}
+	if t.Default != "skip" {
+		s["app.default"] = t.Default == "true"
+	}
+	if t.Deny {
+		s["validate.mode"] = "deny"
+	}
+	d := t.Defaulters
+	if d.RunAsNonRoot.Enabled {
+		s["defaulters.runAsNonRoot.enabled"] = true
+		s["defaulters.runAsNonRoot.value"] = d.RunAsNonRoot.Value
+	}
+	if d.ReadOnlyRootFilesystem.Enabled {
+		s["defaulters.readOnlyRootFilesystem.enabled"] = true
+		s["defaulters.readOnlyRootFilesystem.value"] = d.ReadOnlyRootFilesystem.Value
+	}
+	if d.DropCapabilities.Enabled {
+		s["defaulters.dropCapabilities.enabled"] = true
+		s["defaulters.dropCapabilities.value"] = d.DropCapabilities.Value
+	}
+	return s
+}
+
+// configFragment merges the translations into a single config, the first policy setting a key wins
+func configFragment(translations []Translation) ([]byte, []string, error) {
+	merged := map[string]interface{}{}
+	source := map[string]string{}
+	var notes []string
+	for _, t := range translations {
+		if t.Default == "skip" {
+			notes = append(notes, fmt.Sprintf("%s %s does not default allowPrivilegeEscalation, app.default always applies unless a DefaultingPolicy skips it", Kind, t.Name))
+		}
+		s := settings(t)
+		keys := make([]string, 0, len(s))
+		for key := range s {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if _, ok := merged[key]; !ok {
+				merged[key] = s[key]
+				source[key] = t.Name
+				continue
+			}
+			if fmt.Sprint(merged[key]) != fmt.Sprint(s[key]) {
+				notes = append(notes, fmt.Sprintf("%s %s sets %s to %v, conflicts with %s %s, kept %v", Kind, t.Name, key, s[key], Kind, source[key], merged[key]))
+			}
+		}
+	}
+	// the webhook rejects a config denying the allowPrivilegeEscalation true it defaults
+	if merged["validate.mode"] == "deny" && merged["app.default"] == true {
+		delete(merged, "validate.mode")
+		notes = append(notes, fmt.Sprintf("%s %s sets validate.mode to deny, conflicts with app.default true from %s %s, dropped validate.mode", Kind, source["validate.mode"], Kind, source["app.default"]))
+	}
+	if len(translations) > 1 {
+		notes = append(notes, "PodSecurityPolicies are bound to users and service accounts, the config applies to all pods in scope")
+	}
+
+	nested := map[string]interface{}{}
+	for key, value := range merged {
+		parts := strings.Split(key, ".")
+		m := nested
+		for _, part := range parts[:len(parts)-1] {
+			if _, ok := m[part]; !ok {
+				m[part] = map[string]interface{}{}
+			}
+			m = m[part].(map[string]interface{})
+		}
+		m[parts[len(parts)-1]] = value
+	}
+	out, err := yaml.Marshal(nested)
+	if err != nil {
+		return nil, nil, err
+	}
+	return out, notes, nil
+}
+
+// policyObject is a DefaultingPolicy without status
+type policyObject struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	Spec policy.Spec `json:"spec"`
+}
+
+// policies emits a DefaultingPolicy per translation, settings without a policy equivalent are noted. PSPs are bound
+// through RBAC which cannot be expressed by a policy, so each policy only selects pods labeled with the PSP name.
+func policies(translations []Translation) ([]byte, []string, error) {
+	var docs [][]byte
+	var notes []string
+	for _, t := range translations {
+		// a skip policy would disable defaulting for every pod it selects
+		if t.Default == "skip" {
+			notes = append(notes, fmt.Sprintf("%s %s does not default allowPrivilegeEscalation, no DefaultingPolicy emitted", Kind, t.Name))
+		} else {
+			p := policyObject{
+				APIVersion: fmt.Sprintf("%s/%s", policy.Group, policy.Version),
+				Kind:       policy.ClusterKind,
+				Spec: policy.Spec{
+					PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{SelectorLabel: t.Name}},
+					Default:     t.Default,
+				},
+			}
+			p.Metadata.Name = t.Name
+			doc, err := yaml.Marshal(p)
+			if err != nil {
+				return nil, nil, err
+			}
+			docs = append(docs, doc)
+		}
+
+		var keys []string
+		for key := range settings(t) {
+			if key != "app.default" && !strings.HasSuffix(key, ".value") {
+				keys = append(keys, strings.TrimSuffix(key, ".enabled"))
+			}
+		}
+		if len(keys) > 0 {
+			sort.Strings(keys)
+			notes = append(notes, fmt.Sprintf("%s %s also translates to %s, only emitted with -output %s", Kind, t.Name, strings.Join(keys, ", "), OutputConfig))
+		}
+	}
+	if len(docs) > 0 {
+		notes = append(notes, fmt.Sprintf("PodSecurityPolicies are bound to users and service accounts, the DefaultingPolicies only select pods labeled %s=<name>, label the pods or replace the selectors", SelectorLabel))
+	}
+	return []byte(joinDocuments(docs)), notes, nil
+}
+
+func joinDocuments(docs [][]byte) string {
+	parts := make([]string, 0, len(docs))
+	for _, doc := range docs {
+		parts = append(parts, string(doc))
+	}
+	return strings.Join(parts, "---\n")
+}
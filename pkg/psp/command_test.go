@@ -0,0 +1,146 @@
+package psp
+
+import (
+	"bytes"
+	"defaultallowpe/pkg/config"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "psp-import")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "restricted.yaml")
+	if err := ioutil.WriteFile(file, []byte(restricted), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tt := []struct {
+		name           string
+		args           []string
+		stdin          string
+		code           int
+		expectedStdout string
+		expectedStderr []string
+	}{
+		{
+			name: "config",
+			args: []string{file},
+			expectedStdout: `app:
+  default: false
+defaulters:
+  dropCapabilities:
+    enabled: true
+    value:
+    - ALL
+  readOnlyRootFilesystem:
+    enabled: true
+    value: true
+  runAsNonRoot:
+    enabled: true
+    value: true
+validate:
+  mode: deny
+`,
+			expectedStderr: []string{"untranslated: PodSecurityPolicy restricted: fsGroup: MustRunAs defaults fsGroup to 1 and restricts it to 1-65535, the webhook neither defaults nor restricts fsGroup"},
+		},
+		{
+			name:  "conflicting policies",
+			args:  []string{file, "-"},
+			stdin: privileged,
+			expectedStderr: []string{
+				"note: PodSecurityPolicy privileged sets app.default to true, conflicts with PodSecurityPolicy restricted, kept false",
+				"note: PodSecurityPolicies are bound to users and service accounts, the config applies to all pods in scope",
+			},
+		},
+		{
+			name:  "deny conflicts with default",
+			args:  []string{"-", file},
+			stdin: privileged,
+			expectedStderr: []string{
+				"note: PodSecurityPolicy restricted sets validate.mode to deny, conflicts with app.default true from PodSecurityPolicy privileged, dropped validate.mode",
+			},
+		},
+		{
+			name:  "policy",
+			args:  []string{"-output", "policy"},
+			stdin: privileged,
+			expectedStdout: `apiVersion: default-allow-privilege-escalation.marshallford.me/v1alpha1
+kind: DefaultingPolicy
+metadata:
+  name: privileged
+spec:
+  default: "true"
+  podSelector:
+    matchLabels:
+      default-allow-privilege-escalation.marshallford.me/psp: privileged
+`,
+			expectedStderr: []string{"untranslated: PodSecurityPolicy privileged: runAsUser: MustRunAs defaults runAsUser to 1000 and restricts it to 1000-2000, the webhook neither defaults nor restricts runAsUser"},
+		},
+		{
+			name:           "skip policy",
+			args:           []string{"-output", "policy", "-"},
+			stdin:          "apiVersion: policy/v1beta1\nkind: PodSecurityPolicy\nmetadata:\n  name: empty\nspec: {}\n",
+			expectedStderr: []string{"note: PodSecurityPolicy empty does not default allowPrivilegeEscalation, no DefaultingPolicy emitted"},
+		},
+		{
+			name:           "invalid output",
+			args:           []string{"-output", "json"},
+			code:           2,
+			expectedStderr: []string{`psp-import: invalid output "json", expected config or policy`},
+		},
+		{
+			name:           "missing file",
+			args:           []string{filepath.Join(dir, "missing.yaml")},
+			code:           1,
+			expectedStderr: []string{"no such file or directory"},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			code := Command(tc.args, strings.NewReader(tc.stdin), &stdout, &stderr)
+			if code != tc.code {
+				t.Errorf("expected exit code %d, got %d: %s", tc.code, code, stderr.String())
+			}
+			if tc.expectedStdout != "" && stdout.String() != tc.expectedStdout {
+				t.Errorf("expected output %s, got %s", tc.expectedStdout, stdout.String())
+			}
+			for _, expected := range tc.expectedStderr {
+				if !strings.Contains(stderr.String(), expected) {
+					t.Errorf("expected stderr containing %s, got %s", expected, stderr.String())
+				}
+			}
+		})
+	}
+}
+
+func TestCommandConfigLoads(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := Command(nil, strings.NewReader(restricted), &stdout, &stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+
+	// the emitted config is accepted by the webhook
+	v := viper.New()
+	v.SetConfigType("yaml")
+	if err := v.ReadConfig(&stdout); err != nil {
+		t.Fatal(err)
+	}
+	var c config.Defaulters
+	if err := v.UnmarshalKey("defaulters", &c); err != nil {
+		t.Fatal(err)
+	}
+	if !c.RunAsNonRoot.Enabled || len(c.DropCapabilities.Value) != 1 {
+		t.Errorf("expected defaulters from config, got %+v", c)
+	}
+}
@@ -0,0 +1,183 @@
+package psp
+
+import (
+	"bytes"
+	"defaultallowpe/pkg/config"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// Kind of the objects read
+const Kind = "PodSecurityPolicy"
+
+// translatedFields are the PodSecurityPolicy spec fields considered by Translate, which reports what they lose
+var translatedFields = map[string]bool{
+	"defaultAllowPrivilegeEscalation": true,
+	"allowPrivilegeEscalation":        true,
+	"defaultAddCapabilities":          true,
+	"requiredDropCapabilities":        true,
+	"runAsUser":                       true,
+	"fsGroup":                         true,
+	"readOnlyRootFilesystem":          true,
+}
+
+// Translation is the webhook equivalent of a PodSecurityPolicy
+type Translation struct {
+	Name string
+	// Default for allowPrivilegeEscalation is true, false or skip
+	Default string
+	// Deny is set when the policy forbids allowPrivilegeEscalation
+	Deny       bool
+	Defaulters config.Defaulters
+	// Untranslated describes the fields without a webhook equivalent
+	Untranslated []string
+}
+
+// document is a single YAML or JSON document, a PodSecurityPolicy or a List of them
+type document struct {
+	metav1.TypeMeta `json:",inline"`
+	Items           []json.RawMessage `json:"items"`
+}
+
+// Read decodes the PodSecurityPolicies in a stream of YAML or JSON documents and translates them
+func Read(r io.Reader) ([]Translation, error) {
+	var translations []Translation
+	decoder := yaml.NewYAMLOrJSONDecoder(r, 4096)
+	for i := 1; ; i++ {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); errors.Is(err, io.EOF) {
+			return translations, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("document %d: %w", i, err)
+		}
+		if len(bytes.TrimSpace(raw)) == 0 || bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+			continue
+		}
+
+		var doc document
+		if err := json.Unmarshal(raw, &doc); err != nil {
+			return nil, fmt.Errorf("document %d: %w", i, err)
+		}
+		items := []json.RawMessage{raw}
+		if strings.HasSuffix(doc.Kind, "List") {
+			items = doc.Items
+		}
+		for _, item := range items {
+			t, err := decode(item)
+			if err != nil {
+				return nil, fmt.Errorf("document %d: %w", i, err)
+			}
+			translations = append(translations, t)
+		}
+	}
+}
+
+func decode(raw []byte) (Translation, error) {
+	var p policyv1beta1.PodSecurityPolicy
+	if err := json.Unmarshal(raw, &p); err != nil {
+		return Translation{}, err
+	}
+	if p.Kind != Kind {
+		return Translation{}, fmt.Errorf("expected %s, got %q", Kind, p.Kind)
+	}
+
+	// the fields present in the manifest, including those set to their zero value
+	var fields struct {
+		Spec map[string]json.RawMessage `json:"spec"`
+	}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return Translation{}, err
+	}
+	var present []string
+	for field := range fields.Spec {
+		present = append(present, field)
+	}
+	return Translate(&p, present), nil
+}
+
+// first describes the value a strategy defaults to, the minimum of its first range
+func first(ranges []policyv1beta1.IDRange) string {
+	if len(ranges) == 0 {
+		return "nothing"
+	}
+	return fmt.Sprintf("%d", ranges[0].Min)
+}
+
+// idRanges describes the IDs permitted by a strategy
+func idRanges(ranges []policyv1beta1.IDRange) string {
+	if len(ranges) == 0 {
+		return "any ID"
+	}
+	described := make([]string, 0, len(ranges))
+	for _, r := range ranges {
+		described = append(described, fmt.Sprintf("%d-%d", r.Min, r.Max))
+	}
+	return strings.Join(described, ", ")
+}
+
+// Translate maps the defaulting fields of a PodSecurityPolicy onto the webhook, present lists the spec fields set in the manifest
+func Translate(p *policyv1beta1.PodSecurityPolicy, present []string) Translation {
+	s := p.Spec
+	t := Translation{Name: p.Name, Default: "skip"}
+
+	// allowPrivilegeEscalation defaults to false when escalation is forbidden
+	switch {
+	case s.DefaultAllowPrivilegeEscalation != nil:
+		t.Default = fmt.Sprintf("%t", *s.DefaultAllowPrivilegeEscalation)
+	case s.AllowPrivilegeEscalation != nil && !*s.AllowPrivilegeEscalation:
+		t.Default = "false"
+	}
+	t.Deny = s.AllowPrivilegeEscalation != nil && !*s.AllowPrivilegeEscalation
+
+	if len(s.DefaultAddCapabilities) > 0 {
+		t.Untranslated = append(t.Untranslated, fmt.Sprintf("defaultAddCapabilities: adds %v, the webhook does not add capabilities", s.DefaultAddCapabilities))
+	}
+	if len(s.RequiredDropCapabilities) > 0 {
+		capabilities := make([]string, 0, len(s.RequiredDropCapabilities))
+		for _, capability := range s.RequiredDropCapabilities {
+			capabilities = append(capabilities, string(capability))
+		}
+		t.Defaulters.DropCapabilities = config.ListDefaulter{Enabled: true, Value: capabilities}
+		t.Untranslated = append(t.Untranslated, "requiredDropCapabilities: only defaulted for containers without capabilities.drop, the capabilities are not added to existing drop lists")
+	}
+
+	// the webhook only defaults fields, the restrictions of these strategies are lost
+	switch s.RunAsUser.Rule {
+	case policyv1beta1.RunAsUserStrategyMustRunAsNonRoot:
+		t.Defaulters.RunAsNonRoot = config.BoolDefaulter{Enabled: true, Value: true}
+		t.Untranslated = append(t.Untranslated, "runAsUser: MustRunAsNonRoot is only defaulted through runAsNonRoot, containers setting runAsNonRoot false are not rejected")
+	case policyv1beta1.RunAsUserStrategyMustRunAs:
+		t.Untranslated = append(t.Untranslated, fmt.Sprintf("runAsUser: MustRunAs defaults runAsUser to %s and restricts it to %s, the webhook neither defaults nor restricts runAsUser", first(s.RunAsUser.Ranges), idRanges(s.RunAsUser.Ranges)))
+	}
+	switch s.FSGroup.Rule {
+	case policyv1beta1.FSGroupStrategyMustRunAs:
+		t.Untranslated = append(t.Untranslated, fmt.Sprintf("fsGroup: MustRunAs defaults fsGroup to %s and restricts it to %s, the webhook neither defaults nor restricts fsGroup", first(s.FSGroup.Ranges), idRanges(s.FSGroup.Ranges)))
+	case policyv1beta1.FSGroupStrategyMayRunAs:
+		t.Untranslated = append(t.Untranslated, fmt.Sprintf("fsGroup: MayRunAs restricts fsGroup to %s, the webhook does not restrict fsGroup", idRanges(s.FSGroup.Ranges)))
+	}
+	if s.ReadOnlyRootFilesystem {
+		t.Defaulters.ReadOnlyRootFilesystem = config.BoolDefaulter{Enabled: true, Value: true}
+		t.Untranslated = append(t.Untranslated, "readOnlyRootFilesystem: only defaulted, containers setting readOnlyRootFilesystem false are not rejected")
+	}
+
+	// the remaining fields only restrict pods
+	var enforced []string
+	for _, field := range present {
+		if !translatedFields[field] {
+			enforced = append(enforced, field)
+		}
+	}
+	if len(enforced) > 0 {
+		sort.Strings(enforced)
+		t.Untranslated = append(t.Untranslated, fmt.Sprintf("%s: only enforced by PodSecurityPolicy, the webhook only sets defaults", strings.Join(enforced, ", ")))
+	}
+	return t
+}
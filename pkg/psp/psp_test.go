@@ -0,0 +1,199 @@
+package psp
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const restricted = `apiVersion: policy/v1beta1
+kind: PodSecurityPolicy
+metadata:
+  name: restricted
+spec:
+  privileged: false
+  allowPrivilegeEscalation: false
+  requiredDropCapabilities: [ALL]
+  defaultAddCapabilities: [NET_BIND_SERVICE]
+  volumes: [configMap, secret]
+  runAsUser:
+    rule: MustRunAsNonRoot
+  seLinux:
+    rule: RunAsAny
+  supplementalGroups:
+    rule: RunAsAny
+  fsGroup:
+    rule: MustRunAs
+    ranges: [{min: 1, max: 65535}]
+  readOnlyRootFilesystem: true
+`
+
+const privileged = `apiVersion: policy/v1beta1
+kind: PodSecurityPolicy
+metadata:
+  name: privileged
+spec:
+  defaultAllowPrivilegeEscalation: true
+  runAsUser:
+    rule: MustRunAs
+    ranges: [{min: 1000, max: 2000}]
+  seLinux:
+    rule: RunAsAny
+  supplementalGroups:
+    rule: RunAsAny
+  fsGroup:
+    rule: RunAsAny
+`
+
+func TestRead(t *testing.T) {
+	tt := []struct {
+		name         string
+		input        string
+		names        []string
+		defaults     []string
+		untranslated []string
+		error        string
+	}{
+		{
+			name:     "documents",
+			input:    restricted + "---\n" + privileged,
+			names:    []string{"restricted", "privileged"},
+			defaults: []string{"false", "true"},
+			untranslated: []string{
+				"defaultAddCapabilities: adds [NET_BIND_SERVICE], the webhook does not add capabilities",
+				"requiredDropCapabilities: only defaulted for containers without capabilities.drop, the capabilities are not added to existing drop lists",
+				"runAsUser: MustRunAsNonRoot is only defaulted through runAsNonRoot, containers setting runAsNonRoot false are not rejected",
+				"fsGroup: MustRunAs defaults fsGroup to 1 and restricts it to 1-65535, the webhook neither defaults nor restricts fsGroup",
+				"readOnlyRootFilesystem: only defaulted, containers setting readOnlyRootFilesystem false are not rejected",
+				"privileged, seLinux, supplementalGroups, volumes: only enforced by PodSecurityPolicy, the webhook only sets defaults",
+				"runAsUser: MustRunAs defaults runAsUser to 1000 and restricts it to 1000-2000, the webhook neither defaults nor restricts runAsUser",
+				"seLinux, supplementalGroups: only enforced by PodSecurityPolicy, the webhook only sets defaults",
+			},
+		},
+		{
+			name:     "list",
+			input:    `{"apiVersion": "v1", "kind": "List", "items": [{"apiVersion": "policy/v1beta1", "kind": "PodSecurityPolicy", "metadata": {"name": "empty"}, "spec": {}}]}`,
+			names:    []string{"empty"},
+			defaults: []string{"skip"},
+		},
+		{
+			name:  "empty documents",
+			input: "---\n---\n",
+		},
+		{
+			name:  "other kind",
+			input: restricted + "---\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: foo\n",
+			error: `document 2: expected PodSecurityPolicy, got "ConfigMap"`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			translations, err := Read(strings.NewReader(tc.input))
+			if tc.error != "" {
+				if err == nil || err.Error() != tc.error {
+					t.Errorf("expected error %s, got %v", tc.error, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %s", err)
+			}
+			var names, defaults, untranslated []string
+			for _, translation := range translations {
+				names = append(names, translation.Name)
+				defaults = append(defaults, translation.Default)
+				untranslated = append(untranslated, translation.Untranslated...)
+			}
+			if fmt.Sprint(names) != fmt.Sprint(tc.names) {
+				t.Errorf("expected names %v, got %v", tc.names, names)
+			}
+			if fmt.Sprint(defaults) != fmt.Sprint(tc.defaults) {
+				t.Errorf("expected defaults %v, got %v", tc.defaults, defaults)
+			}
+			if strings.Join(untranslated, "\n") != strings.Join(tc.untranslated, "\n") {
+				t.Errorf("expected untranslated %v, got %v", tc.untranslated, untranslated)
+			}
+		})
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	translations, err := Read(strings.NewReader(restricted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := translations[0].Defaulters
+	if !translations[0].Deny {
+		t.Error("expected deny, got allow")
+	}
+	if !d.RunAsNonRoot.Enabled || !d.RunAsNonRoot.Value {
+		t.Errorf("expected runAsNonRoot defaulted to true, got %+v", d.RunAsNonRoot)
+	}
+	if !d.ReadOnlyRootFilesystem.Enabled || !d.ReadOnlyRootFilesystem.Value {
+		t.Errorf("expected readOnlyRootFilesystem defaulted to true, got %+v", d.ReadOnlyRootFilesystem)
+	}
+	if !d.DropCapabilities.Enabled || fmt.Sprint(d.DropCapabilities.Value) != "[ALL]" {
+		t.Errorf("expected capabilities [ALL] dropped, got %+v", d.DropCapabilities)
+	}
+	if d.SeccompProfile.Enabled || d.ProcMount.Enabled {
+		t.Error("expected seccompProfile and procMount not defaulted")
+	}
+}
+
+func TestTranslateEnforced(t *testing.T) {
+	tt := []struct {
+		name         string
+		spec         string
+		untranslated string
+	}{
+		{
+			name:         "runAsUser MustRunAs",
+			spec:         "runAsUser: {rule: MustRunAs, ranges: [{min: 1000, max: 2000}, {min: 3000, max: 3000}]}",
+			untranslated: "runAsUser: MustRunAs defaults runAsUser to 1000 and restricts it to 1000-2000, 3000-3000, the webhook neither defaults nor restricts runAsUser",
+		},
+		{
+			name:         "runAsUser MustRunAsNonRoot",
+			spec:         "runAsUser: {rule: MustRunAsNonRoot}",
+			untranslated: "runAsUser: MustRunAsNonRoot is only defaulted through runAsNonRoot, containers setting runAsNonRoot false are not rejected",
+		},
+		{
+			name:         "fsGroup MustRunAs",
+			spec:         "fsGroup: {rule: MustRunAs, ranges: [{min: 1, max: 65535}]}",
+			untranslated: "fsGroup: MustRunAs defaults fsGroup to 1 and restricts it to 1-65535, the webhook neither defaults nor restricts fsGroup",
+		},
+		{
+			name:         "fsGroup MayRunAs",
+			spec:         "fsGroup: {rule: MayRunAs, ranges: [{min: 2000, max: 3000}]}",
+			untranslated: "fsGroup: MayRunAs restricts fsGroup to 2000-3000, the webhook does not restrict fsGroup",
+		},
+		{
+			name:         "readOnlyRootFilesystem",
+			spec:         "readOnlyRootFilesystem: true",
+			untranslated: "readOnlyRootFilesystem: only defaulted, containers setting readOnlyRootFilesystem false are not rejected",
+		},
+		{
+			name:         "requiredDropCapabilities",
+			spec:         "requiredDropCapabilities: [NET_RAW]",
+			untranslated: "requiredDropCapabilities: only defaulted for containers without capabilities.drop, the capabilities are not added to existing drop lists",
+		},
+		{
+			name: "runAsAny",
+			spec: "runAsUser: {rule: RunAsAny}\n  fsGroup: {rule: RunAsAny}",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			input := fmt.Sprintf("apiVersion: policy/v1beta1\nkind: PodSecurityPolicy\nmetadata:\n  name: foo\nspec:\n  %s\n", tc.spec)
+			translations, err := Read(strings.NewReader(input))
+			if err != nil {
+				t.Fatalf("expected no error, got %s", err)
+			}
+			untranslated := strings.Join(translations[0].Untranslated, "\n")
+			if untranslated != tc.untranslated {
+				t.Errorf("expected untranslated %q, got %q", tc.untranslated, untranslated)
+			}
+		})
+	}
+}
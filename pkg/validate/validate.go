@@ -0,0 +1,202 @@
+package validate
+
+import (
+	"defaultallowpe/pkg/admission"
+	"defaultallowpe/pkg/config"
+	"defaultallowpe/pkg/metrics"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Modes for containers explicitly allowing privilege escalation
+const (
+	ModeDeny = "deny"
+	ModeWarn = "warn"
+)
+
+// options controls which objects are validated and how violations are reported
+type options struct {
+	mode                  string
+	exemptNamespaces      []string
+	exemptServiceAccounts []string
+	exemptImages          []string
+}
+
+// Routes manages Fiber routes for validate pkg
+func Routes(r fiber.Router, config *config.Snapshot, log *zap.SugaredLogger) {
+	r.Post("/validate", metrics.Instrument("validate", HandlerFunc(config, log)))
+}
+
+// HandlerFunc returns a func that is a HTTP handler for validate requests
+func HandlerFunc(config *config.Snapshot, log *zap.SugaredLogger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		review, appErr := admission.ReadReview(c)
+		if appErr != nil {
+			metrics.Admissions.WithLabelValues("validate", "", "", metrics.OutcomeError).Inc()
+			log.Warnw("invalid admission review",
+				"err", appErr.Message,
+			)
+			return c.Status(appErr.Status).JSON(appErr)
+		}
+
+		// validate using a consistent config snapshot
+		cfg := config.Load()
+		admissionResponse := validate(review, options{
+			mode:                  cfg.Validate.Mode,
+			exemptNamespaces:      cfg.Validate.Exempt.Namespaces,
+			exemptServiceAccounts: cfg.Validate.Exempt.ServiceAccounts,
+			exemptImages:          cfg.Validate.Exempt.Images,
+		}, log)
+		log.Infow("admission reviewed",
+			"uid", string(review.Request.UID),
+			"namespace", review.Request.Namespace,
+			"kind", review.Request.Kind.Kind,
+			"subResource", review.Request.SubResource,
+			"operation", string(review.Request.Operation),
+			"user", review.Request.UserInfo.Username,
+			"outcome", outcome(admissionResponse),
+			"warnings", admissionResponse.Warnings,
+		)
+		metrics.Admissions.WithLabelValues("validate", review.Request.Namespace, string(review.Request.Operation), outcome(admissionResponse)).Inc()
+
+		// return new AdmissionReview
+		return admission.WriteReview(c, review, admissionResponse)
+	}
+}
+
+// outcome classifies a validation response for metrics
+func outcome(res *admissionv1.AdmissionResponse) string {
+	switch {
+	case res.Result != nil && res.Result.Reason == metav1.StatusReasonForbidden:
+		return metrics.OutcomeDenied
+	case res.Result != nil && res.Result.Status == metav1.StatusFailure:
+		return metrics.OutcomeError
+	case len(res.Warnings) > 0:
+		return metrics.OutcomeWarned
+	}
+	return metrics.OutcomeAllowed
+}
+
+// exempt checks the namespace and service account exemptions, describing the rule that matched
+func exempt(pt *admission.PodTemplate, opts options) (string, bool) {
+	if pattern, ok := admission.MatchGlob(opts.exemptNamespaces, pt.Meta.Namespace); ok {
+		return fmt.Sprintf("validate.exempt.namespaces %q", pattern), true
+	}
+	serviceAccount := pt.Spec.ServiceAccountName
+	if serviceAccount == "" {
+		serviceAccount = "default"
+	}
+	if pattern, ok := admission.MatchGlob(opts.exemptServiceAccounts, pt.Meta.Namespace+"/"+serviceAccount); ok {
+		return fmt.Sprintf("validate.exempt.serviceAccounts %q", pattern), true
+	}
+	return "", false
+}
+
+func validate(ar *admissionv1.AdmissionReview, opts options, log *zap.SugaredLogger) *admissionv1.AdmissionResponse {
+	obj, err := admission.DecodeObject(ar.Request.Object.Raw)
+	if err != nil {
+		return &admissionv1.AdmissionResponse{
+			Result: &metav1.Status{
+				Message: err.Error(),
+				Status:  metav1.StatusFailure,
+			},
+		}
+	}
+
+	pt, ok := admission.PodTemplateFor(obj)
+	if !ok {
+		return &admissionv1.AdmissionResponse{
+			Result: &metav1.Status{
+				Message: fmt.Sprintf("unexpected type %T", obj),
+				Status:  metav1.StatusFailure,
+			},
+		}
+	}
+
+	// the object's namespace may not be set yet when created
+	if pt.Meta.Namespace == "" {
+		pt.Meta.Namespace = ar.Request.Namespace
+	}
+
+	// the containers of an existing Pod cannot change, metadata updates such as removing finalizers must pass
+	ephemeralOnly := ar.Request.SubResource == admission.EphemeralContainersSubResource
+	if pt.Immutable && ar.Request.Operation == admissionv1.Update && !ephemeralOnly {
+		return &admissionv1.AdmissionResponse{
+			Allowed: true,
+		}
+	}
+
+	// check if object is exempt
+	if rule, ok := exempt(pt, opts); ok {
+		log.Debugw("exempt from validation",
+			"namespace", pt.Meta.Namespace,
+			"rule", rule,
+		)
+		return &admissionv1.AdmissionResponse{
+			Allowed: true,
+		}
+	}
+
+	// only ephemeral containers being added are checked, existing ones were admitted before
+	existing := map[string]bool{}
+	if ephemeralOnly {
+		if existing, err = admission.ExistingEphemeralContainers(ar.Request.OldObject.Raw); err != nil {
+			return &admissionv1.AdmissionResponse{
+				Result: &metav1.Status{
+					Message: err.Error(),
+					Status:  metav1.StatusFailure,
+				},
+			}
+		}
+	}
+
+	// look for containers explicitly allowing privilege escalation
+	var violations []string
+	for _, c := range pt.Containers(ephemeralOnly) {
+		if existing[c.Name] {
+			continue
+		}
+		sc := c.SecurityContext
+		if sc == nil || sc.AllowPrivilegeEscalation == nil || !*sc.AllowPrivilegeEscalation {
+			continue
+		}
+		if pattern, ok := admission.MatchGlob(opts.exemptImages, c.Image); ok {
+			log.Debugw("exempt from validation",
+				"namespace", pt.Meta.Namespace,
+				"container", c.Name,
+				"rule", fmt.Sprintf("validate.exempt.images %q", pattern),
+			)
+			continue
+		}
+		violations = append(violations, fmt.Sprintf("%s %q sets allowPrivilegeEscalation to true", c.Kind, c.Name))
+	}
+
+	if len(violations) == 0 {
+		return &admissionv1.AdmissionResponse{
+			Allowed: true,
+		}
+	}
+
+	if opts.mode != ModeDeny {
+		return &admissionv1.AdmissionResponse{
+			Allowed:  true,
+			Warnings: violations,
+		}
+	}
+
+	return &admissionv1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Message: strings.Join(violations, ", "),
+			Status:  metav1.StatusFailure,
+			Reason:  metav1.StatusReasonForbidden,
+			Code:    fiber.StatusForbidden,
+		},
+	}
+}
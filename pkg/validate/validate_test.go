@@ -0,0 +1,260 @@
+package validate
+
+import (
+	"bytes"
+	"defaultallowpe/pkg/config"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+var (
+	log = zap.NewNop().Sugar()
+
+	escalation = func() *bool {
+		b := true
+		return &b
+	}()
+	noEscalation = func() *bool {
+		b := false
+		return &b
+	}()
+)
+
+func pod(ns string, serviceAccount string, containers ...corev1.Container) corev1.Pod {
+	return corev1.Pod{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Pod",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "some-pod",
+			Namespace: ns,
+		},
+		Spec: corev1.PodSpec{
+			ServiceAccountName: serviceAccount,
+			Containers:         containers,
+		},
+	}
+}
+
+func container(name string, image string, allowPrivilegeEscalation *bool) corev1.Container {
+	return corev1.Container{
+		Name:            name,
+		Image:           image,
+		SecurityContext: &corev1.SecurityContext{AllowPrivilegeEscalation: allowPrivilegeEscalation},
+	}
+}
+
+func review(t *testing.T, obj interface{}) *admissionv1.AdmissionReview {
+	objBytes, err := json.Marshal(obj)
+	if err != nil {
+		t.Fatal("failed to json encode object")
+	}
+	return &admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "admission.k8s.io/v1",
+			Kind:       "AdmissionReview",
+		},
+		Request: &admissionv1.AdmissionRequest{
+			UID:       "e911857d-c318-11e8-bbad-025000000001",
+			Kind:      metav1.GroupVersionKind{Kind: "Pod"},
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: objBytes},
+		},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tt := []struct {
+		name             string
+		input            corev1.Pod
+		opts             options
+		expectedAllowed  bool
+		expectedWarnings int
+		expectedMessage  string
+	}{
+		{
+			name:            "not set",
+			input:           pod("default", "", corev1.Container{Name: "foo", Image: "image:tag"}),
+			opts:            options{mode: ModeDeny},
+			expectedAllowed: true,
+		},
+		{
+			name:            "false",
+			input:           pod("default", "", container("foo", "image:tag", noEscalation)),
+			opts:            options{mode: ModeDeny},
+			expectedAllowed: true,
+		},
+		{
+			name:            "deny",
+			input:           pod("default", "", container("foo", "image:tag", escalation), container("bar", "image:tag", escalation)),
+			opts:            options{mode: ModeDeny},
+			expectedAllowed: false,
+			expectedMessage: `container "foo" sets allowPrivilegeEscalation to true, container "bar" sets allowPrivilegeEscalation to true`,
+		},
+		{
+			name:             "warn",
+			input:            pod("default", "", container("foo", "image:tag", escalation)),
+			opts:             options{mode: ModeWarn},
+			expectedAllowed:  true,
+			expectedWarnings: 1,
+		},
+		{
+			name:            "exempt namespace",
+			input:           pod("kube-system", "", container("foo", "image:tag", escalation)),
+			opts:            options{mode: ModeDeny, exemptNamespaces: []string{"kube-*"}},
+			expectedAllowed: true,
+		},
+		{
+			name:            "exempt service account",
+			input:           pod("monitoring", "node-agent", container("foo", "image:tag", escalation)),
+			opts:            options{mode: ModeDeny, exemptServiceAccounts: []string{"monitoring/node-agent"}},
+			expectedAllowed: true,
+		},
+		{
+			name:            "exempt default service account",
+			input:           pod("monitoring", "", container("foo", "image:tag", escalation)),
+			opts:            options{mode: ModeDeny, exemptServiceAccounts: []string{"*/default"}},
+			expectedAllowed: true,
+		},
+		{
+			name:            "exempt image",
+			input:           pod("default", "", container("foo", "registry.example.com/vendor/ping:1", escalation), container("bar", "image:tag", escalation)),
+			opts:            options{mode: ModeDeny, exemptImages: []string{"registry.example.com/vendor/*"}},
+			expectedAllowed: false,
+			expectedMessage: `container "bar" sets allowPrivilegeEscalation to true`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			res := validate(review(t, tc.input), tc.opts, log)
+			if res.Allowed != tc.expectedAllowed {
+				t.Errorf("expected allowed %t, got %t", tc.expectedAllowed, res.Allowed)
+			}
+			if len(res.Warnings) != tc.expectedWarnings {
+				t.Errorf("expected %d warnings, got %v", tc.expectedWarnings, res.Warnings)
+			}
+			if tc.expectedMessage != "" && res.Result.Message != tc.expectedMessage {
+				t.Errorf("expected message %s, got %s", tc.expectedMessage, res.Result.Message)
+			}
+		})
+	}
+}
+
+func TestValidateUpdate(t *testing.T) {
+	debugger := func(name string, allowPrivilegeEscalation *bool) corev1.EphemeralContainer {
+		return corev1.EphemeralContainer{EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+			Name:            name,
+			Image:           "busybox",
+			SecurityContext: &corev1.SecurityContext{AllowPrivilegeEscalation: allowPrivilegeEscalation},
+		}}
+	}
+	escalating := pod("default", "", container("foo", "image:tag", escalation))
+	debugged := pod("default", "", container("foo", "image:tag", escalation))
+	debugged.Spec.EphemeralContainers = []corev1.EphemeralContainer{debugger("debugger", escalation)}
+
+	tt := []struct {
+		name            string
+		input           corev1.Pod
+		old             corev1.Pod
+		subResource     string
+		expectedAllowed bool
+		expectedMessage string
+	}{
+		{
+			name:            "metadata update",
+			input:           escalating,
+			old:             escalating,
+			expectedAllowed: true,
+		},
+		{
+			name: "existing ephemeral container",
+			input: func() corev1.Pod {
+				p := *debugged.DeepCopy()
+				p.Spec.EphemeralContainers = append(p.Spec.EphemeralContainers, debugger("debugger-2", noEscalation))
+				return p
+			}(),
+			old:             debugged,
+			subResource:     "ephemeralcontainers",
+			expectedAllowed: true,
+		},
+		{
+			name: "added ephemeral container",
+			input: func() corev1.Pod {
+				p := *debugged.DeepCopy()
+				p.Spec.EphemeralContainers = append(p.Spec.EphemeralContainers, debugger("debugger-2", escalation))
+				return p
+			}(),
+			old:             debugged,
+			subResource:     "ephemeralcontainers",
+			expectedAllowed: false,
+			expectedMessage: `ephemeral container "debugger-2" sets allowPrivilegeEscalation to true`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			oldBytes, err := json.Marshal(tc.old)
+			if err != nil {
+				t.Fatal("failed to json encode old object")
+			}
+			ar := review(t, tc.input)
+			ar.Request.Operation = admissionv1.Update
+			ar.Request.SubResource = tc.subResource
+			ar.Request.OldObject = runtime.RawExtension{Raw: oldBytes}
+			res := validate(ar, options{mode: ModeDeny}, log)
+			if res.Allowed != tc.expectedAllowed {
+				t.Errorf("expected allowed %t, got %t", tc.expectedAllowed, res.Allowed)
+			}
+			if tc.expectedMessage != "" && res.Result.Message != tc.expectedMessage {
+				t.Errorf("expected message %s, got %s", tc.expectedMessage, res.Result.Message)
+			}
+		})
+	}
+}
+
+func TestValidateApi(t *testing.T) {
+	arBytes, err := json.Marshal(review(t, pod("default", "", container("foo", "image:tag", escalation))))
+	if err != nil {
+		t.Fatal("failed to json encode AdmissionReview")
+	}
+
+	req := httptest.NewRequest("POST", "/validate", bytes.NewReader(arBytes))
+	req.Header.Set("Content-Type", "application/json")
+
+	config, _ := config.New()
+	app := fiber.New()
+	Routes(app.Group(""), config, log)
+	res, _ := app.Test(req)
+
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("expected status code %d, got %d", http.StatusOK, res.StatusCode)
+	}
+
+	bodyBytes, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	var resBody admissionv1.AdmissionReview
+	err = json.Unmarshal(bodyBytes, &resBody)
+	if err != nil {
+		t.Fatal("failed to json decode res body")
+	}
+	if !resBody.Response.Allowed {
+		t.Error("expected allowed true, got allowed false")
+	}
+	if len(resBody.Response.Warnings) != 1 {
+		t.Errorf("expected 1 warning, got %v", resBody.Response.Warnings)
+	}
+}
@@ -1,24 +1,39 @@
 package webhook
 
 import (
+	"defaultallowpe/pkg/certificate"
+	"defaultallowpe/pkg/config"
 	"defaultallowpe/pkg/health"
 	"defaultallowpe/pkg/mutate"
+	"defaultallowpe/pkg/validate"
+	"fmt"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
-	"github.com/spf13/viper"
+	"go.uber.org/zap"
 )
 
 // New creates a webhook fiber app
-func New(config *viper.Viper) *fiber.App {
+func New(config *config.Snapshot, log *zap.SugaredLogger, listers mutate.Listers, checks health.Checks, monitor *certificate.Monitor) *fiber.App {
 	app := fiber.New(fiber.Config{
 		StrictRouting: true,
 	})
 	api := app.Group("/api", cors.New())
 	v1 := api.Group("/v1")
 
-	health.Routes(v1, config)
-	mutate.Routes(v1, config)
+	// admission reviews require a verified client certificate when mutual TLS is enabled
+	if tls := config.Load().Server.TLS; tls.Enabled && tls.ClientCA != "" {
+		v1.Use("/mutate", certificate.RequireClient)
+		v1.Use("/validate", certificate.RequireClient)
+	}
+
+	health.Routes(v1, checks)
+	if monitor != nil {
+		certificate.Routes(v1, monitor)
+	}
+	mutate.Routes(v1, config, log, listers)
+	validate.Routes(v1, config, log)
 
 	// API 404 handler
 	api.Use(func(c *fiber.Ctx) error {
@@ -35,3 +50,21 @@ func New(config *viper.Viper) *fiber.App {
 
 	return app
 }
+
+// Shutdown marks the webhook as shutting down so readiness fails for the drain period, then stops the app once
+// in-flight requests complete or the timeout elapses
+func Shutdown(app *fiber.App, state *health.State, drain, timeout time.Duration) error {
+	state.ShutDown()
+	time.Sleep(drain)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- app.Shutdown()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("shutdown: in-flight requests did not complete within %s", timeout)
+	}
+}
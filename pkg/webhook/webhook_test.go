@@ -2,18 +2,25 @@ package webhook
 
 import (
 	"defaultallowpe/pkg/config"
+	"defaultallowpe/pkg/health"
+	"defaultallowpe/pkg/mutate"
 	"encoding/json"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
 )
 
 func TestAppNotFound(t *testing.T) {
 	req := httptest.NewRequest("GET", "/foobar", nil)
 
 	config, _ := config.New()
-	app := New(config)
+	app := New(config, zap.NewNop().Sugar(), mutate.Listers{}, health.Checks{}, nil)
 	res, _ := app.Test(req)
 	if res.StatusCode != http.StatusNotFound {
 		t.Errorf("expected status code %d, got %d", http.StatusNotFound, res.StatusCode)
@@ -24,7 +31,7 @@ func TestApiNotFound(t *testing.T) {
 	req := httptest.NewRequest("GET", "/api/vN/foobar", nil)
 
 	config, _ := config.New()
-	app := New(config)
+	app := New(config, zap.NewNop().Sugar(), mutate.Listers{}, health.Checks{}, nil)
 	res, _ := app.Test(req)
 	if res.StatusCode != http.StatusNotFound {
 		t.Errorf("expected status code %d, got %d", http.StatusNotFound, res.StatusCode)
@@ -43,3 +50,83 @@ func TestApiNotFound(t *testing.T) {
 		t.Errorf("expected %s, got %s", expected, resBody["status"])
 	}
 }
+
+func TestShutdown(t *testing.T) {
+	tt := []struct {
+		name    string
+		delay   time.Duration
+		timeout time.Duration
+		err     bool
+	}{
+		{
+			name:    "in-flight request completes",
+			delay:   200 * time.Millisecond,
+			timeout: 5 * time.Second,
+		},
+		{
+			name:    "timeout",
+			delay:   2 * time.Second,
+			timeout: 100 * time.Millisecond,
+			err:     true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			started := make(chan struct{})
+			app := fiber.New(fiber.Config{DisableStartupMessage: true})
+			app.Get("/slow", func(c *fiber.Ctx) error {
+				close(started)
+				time.Sleep(tc.delay)
+				return c.SendString("done")
+			})
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			go func() {
+				_ = app.Listener(ln)
+			}()
+
+			type response struct {
+				status int
+				body   string
+				err    error
+			}
+			responses := make(chan response, 1)
+			go func() {
+				res, err := http.Get("http://" + ln.Addr().String() + "/slow")
+				if err != nil {
+					responses <- response{err: err}
+					return
+				}
+				defer res.Body.Close()
+				body, err := ioutil.ReadAll(res.Body)
+				responses <- response{status: res.StatusCode, body: string(body), err: err}
+			}()
+			<-started
+
+			state := &health.State{}
+			err = Shutdown(app, state, 0, tc.timeout)
+			if !state.ShuttingDown() {
+				t.Error("expected shutting down true, got shutting down false")
+			}
+			if tc.err && err == nil {
+				t.Error("expected error, got none")
+			}
+			if tc.err {
+				return
+			}
+			if err != nil {
+				t.Errorf("expected no error, got %s", err)
+			}
+			res := <-responses
+			if res.err != nil {
+				t.Fatal(res.err)
+			}
+			if res.status != http.StatusOK || res.body != "done" {
+				t.Errorf("expected %d done, got %d %s", http.StatusOK, res.status, res.body)
+			}
+		})
+	}
+}